@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Define a snippet type matching the JSON representation used by the API.
+type snippet struct {
+	ID         int           `json:"id"`
+	UserID     int           `json:"user_id,omitempty"`
+	Title      string        `json:"title"`
+	Visibility string        `json:"visibility"`
+	Created    time.Time     `json:"created"`
+	Expires    time.Time     `json:"expires"`
+	Files      []snippetFile `json:"files"`
+}
+
+type snippetFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+}
+
+// The file method returns the snippet's file with the given name, or its
+// first file if name is empty.
+func (s *snippet) file(name string) (*snippetFile, error) {
+	if name == "" && len(s.Files) > 0 {
+		return &s.Files[0], nil
+	}
+	for i := range s.Files {
+		if s.Files[i].Name == name {
+			return &s.Files[i], nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("snippet #%d has no files", s.ID)
+	}
+	return nil, fmt.Errorf("snippet #%d has no file named %q", s.ID, name)
+}
+
+type snippetInput struct {
+	Title      string        `json:"title"`
+	Files      []snippetFile `json:"files"`
+	Expires    int           `json:"expires"`
+	Visibility string        `json:"visibility"`
+}
+
+// Define an apiError type for problem responses returned by the server.
+type apiError struct {
+	Status int               `json:"status"`
+	Title  string            `json:"title"`
+	Detail string            `json:"detail"`
+	Errors map[string]string `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Title, e.Detail)
+	for field, fieldErr := range e.Errors {
+		msg += fmt.Sprintf("\n  %s: %s", field, fieldErr)
+	}
+	return msg
+}
+
+// Define a client type which talks to the /api/v1 endpoints of a server.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(cfg *config) (*client, error) {
+	if cfg.Server == "" {
+		return nil, errors.New(`no server configured, run "snippet login" first`)
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Insecure {
+		// Development servers use a self-signed certificate.
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	return &client{
+		server: strings.TrimSuffix(cfg.Server, "/"),
+		token:  cfg.Token,
+		http:   httpClient,
+	}, nil
+}
+
+// The do method sends a request to the API, encoding in as the JSON body if
+// it isn't nil, and decodes a successful response into out if it isn't nil.
+// Problem responses are returned as an *apiError.
+func (c *client) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	rs, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode >= 400 {
+		apiErr := &apiError{Status: rs.StatusCode, Title: http.StatusText(rs.StatusCode)}
+		// Not every error comes from the API, e.g. the router's 404 page, so
+		// fall back to the status text if the body isn't a problem object.
+		_ = json.NewDecoder(rs.Body).Decode(apiErr)
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(rs.Body).Decode(out)
+}
+
+func (c *client) createSnippet(input snippetInput) (*snippet, error) {
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	err := c.do(http.MethodPost, "/api/v1/snippets", input, &out)
+	return out.Snippet, err
+}
+
+func (c *client) getSnippet(id int) (*snippet, error) {
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/v1/snippets/%d", id), nil, &out)
+	return out.Snippet, err
+}
+
+// The listSnippets method returns a page of the user's own snippets, or of
+// the public snippets if all is true, and the total number of records.
+func (c *client) listSnippets(all bool, page int) ([]*snippet, int, error) {
+	query := url.Values{}
+	query.Set("page", fmt.Sprint(page))
+	if !all {
+		query.Set("mine", "true")
+	}
+
+	var out struct {
+		Snippets []*snippet `json:"snippets"`
+		Metadata struct {
+			TotalRecords int `json:"total_records"`
+		} `json:"metadata"`
+	}
+	err := c.do(http.MethodGet, "/api/v1/snippets?"+query.Encode(), nil, &out)
+	return out.Snippets, out.Metadata.TotalRecords, err
+}
+
+func (c *client) deleteSnippet(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/v1/snippets/%d", id), nil, nil)
+}
+
+// The verifyToken method checks that the server accepts the client's token.
+// A token without the read scope is still valid, so a 403 is fine here.
+func (c *client) verifyToken() error {
+	err := c.do(http.MethodGet, "/api/v1/snippets?mine=true&page_size=1", nil, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusForbidden {
+		return nil
+	}
+	return err
+}
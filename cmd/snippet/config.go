@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Define a config type to hold the settings written by "snippet login".
+type config struct {
+	Server   string `json:"server"`
+	Token    string `json:"token"`
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// The configPath function returns the location of the config file inside the
+// user's config directory, e.g. ~/.config/snippetbox/config.json on Linux.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snippetbox", "config.json"), nil
+}
+
+// The loadConfig function reads the config file, if there is one, and applies
+// the SNIPPETBOX_SERVER and SNIPPETBOX_TOKEN environment variables on top, so
+// that CI jobs don't need to run "snippet login".
+func loadConfig() (*config, error) {
+	cfg := &config{}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if server := os.Getenv("SNIPPETBOX_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("SNIPPETBOX_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+	return cfg, nil
+}
+
+// The save method writes the config file. It contains a secret, so it is only
+// readable by the current user.
+func (cfg *config) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
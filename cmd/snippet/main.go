@@ -0,0 +1,278 @@
+// Command snippet is a command-line client for a snippetbox server. It talks
+// to the JSON API using a personal API token, for example:
+//
+//	git diff | snippet create -t "review"
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: snippet <command> [flags] [args]
+
+Commands:
+  login               store the server address and an API token
+  create [file...]    create a snippet from files or standard input
+  get <id> [file]     print the raw content of a snippet file
+  list                list your snippets
+  delete <id>         delete one of your snippets
+
+Run "snippet <command> -h" for the flags of each command.
+`
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "snippet:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// The run function executes a single command. It is separate from main so
+// that it can be tested without touching the real standard streams.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "login":
+		return runLogin(args, stdin, stdout, stderr)
+	case "create":
+		return runCreate(args, stdin, stdout, stderr)
+	case "get":
+		return runGet(args, stdout, stderr)
+	case "list":
+		return runList(args, stdout, stderr)
+	case "delete":
+		return runDelete(args, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// The newFlagSet function returns a flag set for a command which reports
+// errors, rather than exiting, and writes its usage to stderr.
+func newFlagSet(name, argsUsage string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: snippet %s [flags] %s\n", name, argsUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// The connect function loads the config and returns a client for it.
+func connect() (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(cfg)
+}
+
+// The parseID function parses the single snippet ID argument of a command.
+func parseID(fs *flag.FlagSet) (int, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 0, errors.New("expected exactly one snippet ID")
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid snippet ID %q", fs.Arg(0))
+	}
+	return id, nil
+}
+
+func runLogin(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("login", "", stderr)
+	server := fs.String("server", "https://localhost:8080", "snippetbox server URL")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Create a token at %s/account/tokens and paste it here: ", strings.TrimSuffix(*server, "/"))
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return errors.New("no token given")
+	}
+
+	cfg := &config{Server: *server, Token: token, Insecure: *insecure}
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	err = c.verifyToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.save()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Logged in.")
+	return nil
+}
+
+func runCreate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("create", "[file...]", stderr)
+	title := fs.String("t", "", "snippet title (defaults to the first file name)")
+	expires := fs.Int("e", 7, "days until the snippet expires (1, 7 or 365)")
+	visibility := fs.String("v", "public", "snippet visibility (public, unlisted or private)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Each file argument becomes a file of the snippet, keeping its base
+	// name. Standard input is stored as a single file named snippet.txt.
+	var files []snippetFile
+	if fs.NArg() == 0 {
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		files = append(files, snippetFile{Name: "snippet.txt", Content: string(content)})
+	}
+	for _, path := range fs.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, snippetFile{Name: filepath.Base(path), Content: string(content)})
+	}
+	if *title == "" && fs.NArg() > 0 {
+		*title = filepath.Base(fs.Arg(0))
+	}
+	if *title == "" {
+		*title = "Untitled"
+	}
+
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	s, err := c.createSnippet(snippetInput{
+		Title:      *title,
+		Files:      files,
+		Expires:    *expires,
+		Visibility: *visibility,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s/snippet/view/%d\n", c.server, s.ID)
+	return nil
+}
+
+func runGet(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("get", "<id> [file]", stderr)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("expected a snippet ID and an optional file name")
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || id < 1 {
+		return fmt.Errorf("invalid snippet ID %q", fs.Arg(0))
+	}
+
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	s, err := c.getSnippet(id)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.file(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(stdout, file.Content)
+	return err
+}
+
+func runList(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("list", "", stderr)
+	all := fs.Bool("all", false, "list the latest public snippets instead of your own")
+	page := fs.Int("page", 1, "page of results to show")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	snippets, total, err := c.listSnippets(*all, *page)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tVISIBILITY\tEXPIRES")
+	for _, s := range snippets {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Title, s.Visibility, s.Expires.UTC().Format("2006-01-02"))
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d of %d snippets\n", len(snippets), total)
+	return nil
+}
+
+func runDelete(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("delete", "<id>", stderr)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(fs)
+	if err != nil {
+		return err
+	}
+
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	err = c.deleteSnippet(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Deleted snippet #%d.\n", id)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+// newFakeServer returns a TLS test server which implements just enough of the
+// snippetbox API for the client, accepting the token "sbx_test".
+func newFakeServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/snippets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"snippets": [{"id": 1, "title": "First", "visibility": "private"}],
+				"metadata": {"total_records": 1}}`))
+		case http.MethodPost:
+			var input snippetInput
+			err := json.NewDecoder(r.Body).Decode(&input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(input.Files) != 1 || input.Files[0].Name != "snippet.txt" || input.Files[0].Content != "diff --git a/x b/x\n" ||
+				input.Title != "review" || input.Visibility != "unlisted" {
+				t.Errorf("unexpected input: %+v", input)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"snippet": {"id": 42}}`))
+		}
+	})
+	mux.HandleFunc("/api/v1/snippets/1", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"snippet": {"id": 1, "files": [
+				{"name": "main.go", "content": "raw content"},
+				{"name": "README.md", "content": "second file"}]}}`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sbx_test" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"status": 401, "title": "Unauthorized", "detail": "The API token is invalid or has been revoked."}`))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func runCmd(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, io.Discard)
+	return stdout.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	ts := newFakeServer(t)
+	defer ts.Close()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("SNIPPETBOX_SERVER", "")
+	t.Setenv("SNIPPETBOX_TOKEN", "")
+
+	_, err := runCmd(t, "", "list")
+	if err == nil {
+		t.Fatal("expected an error before logging in")
+	}
+
+	_, err = runCmd(t, "sbx_wrong\n", "login", "-server", ts.URL, "-insecure")
+	if err == nil {
+		t.Fatal("expected an error for an invalid token")
+	}
+
+	out, err := runCmd(t, "sbx_test\n", "login", "-server", ts.URL, "-insecure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StringContains(t, out, "Logged in.")
+
+	out, err = runCmd(t, "diff --git a/x b/x\n", "create", "-t", "review", "-v", "unlisted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, ts.URL+"/snippet/view/42\n")
+
+	out, err = runCmd(t, "", "get", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, "raw content")
+
+	out, err = runCmd(t, "", "get", "1", "README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, "second file")
+
+	_, err = runCmd(t, "", "get", "1", "missing.txt")
+	if err == nil {
+		t.Fatal("expected an error for an unknown file")
+	}
+
+	out, err = runCmd(t, "", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StringContains(t, out, "First")
+	assert.StringContains(t, out, "1 of 1 snippets")
+
+	out, err = runCmd(t, "", "delete", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, "Deleted snippet #1.\n")
+
+	_, err = runCmd(t, "", "get", "foo")
+	if err == nil {
+		t.Fatal("expected an error for an invalid ID")
+	}
+}
@@ -0,0 +1,534 @@
+// Command snippetadmin operates a snippetbox instance: it manages users and
+// snippets, runs database migrations and prints instance statistics. It reads
+// the same flags and SNIPPETBOX_* environment variables as cmd/web, e.g.
+//
+//	snippetadmin -dsn "web:pass@/snippetbox?parseTime=true" user disable -email bob@example.com
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/config"
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+	"github.com.scottyfionnghall.snippetbox/internal/validator"
+)
+
+const usage = `Usage: snippetadmin [config flags] <command> [flags]
+
+Commands:
+  migrate                 apply pending database migrations
+  stats                   print instance statistics
+  user create             create a user
+  user reset-password     set a new password for a user
+  user disable            disable a user's account
+  user enable             re-enable a user's account
+  user unlock             clear a user's failed logins, ending a lockout
+  user attempts           list a user's recent login attempts
+  snippet list            list snippets by owner or age
+  snippet purge           delete snippets by owner or age
+
+Run "snippetadmin <command> -h" for the flags of each command, and
+"snippetadmin -h" for the config flags shared with the web server.
+`
+
+// Define an admin type to hold the dependencies of the commands. The user
+// and login attempt models are created per command, as preparing their
+// statements fails until the migrations have been run, and tests replace
+// them.
+type admin struct {
+	db     *sql.DB
+	users  func() (userStore, error)
+	logins func() (loginStore, error)
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// The userStore interface holds the UserModel methods used by the
+// commands.
+type userStore interface {
+	Insert(ctx context.Context, name, email, password string) error
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	SetPassword(ctx context.Context, id int, password string) error
+	SetVerified(ctx context.Context, id int) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	CloseAll() error
+}
+
+// The loginStore interface holds the LoginAttemptModel methods used by the
+// commands.
+type loginStore interface {
+	Unlock(ctx context.Context, email string) (int64, error)
+	List(ctx context.Context, email string, limit int) ([]*models.LoginAttempt, error)
+	CloseAll() error
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	hasher, err := cfg.PasswordHasher()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "snippetadmin:", err)
+		os.Exit(2)
+	}
+
+	db, err := config.OpenDB(cfg.DSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "snippetadmin:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	app := &admin{
+		db: db,
+		users: func() (userStore, error) {
+			return models.NewUserModel(db, hasher)
+		},
+		logins: func() (loginStore, error) {
+			return models.NewLoginAttemptModel(db)
+		},
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	err = app.run(context.Background(), flag.Args())
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "snippetadmin:", err)
+		}
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// The run method dispatches to the command named by the first argument.
+func (app *admin) run(ctx context.Context, args []string) error {
+	cmd, args := args[0], args[1:]
+	if (cmd == "user" || cmd == "snippet") && len(args) > 0 {
+		cmd, args = cmd+" "+args[0], args[1:]
+	}
+
+	switch cmd {
+	case "migrate":
+		return app.migrate(ctx, args)
+	case "stats":
+		return app.stats(ctx, args)
+	case "user create":
+		return app.userCreate(ctx, args)
+	case "user reset-password":
+		return app.userResetPassword(ctx, args)
+	case "user disable":
+		return app.userSetDisabled(ctx, args, true)
+	case "user enable":
+		return app.userSetDisabled(ctx, args, false)
+	case "user unlock":
+		return app.userUnlock(ctx, args)
+	case "user attempts":
+		return app.userAttempts(ctx, args)
+	case "snippet list":
+		return app.snippetList(ctx, args)
+	case "snippet purge":
+		return app.snippetPurge(ctx, args)
+	default:
+		fmt.Fprint(app.stderr, usage)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// The newFlagSet method returns a flag set for a command which reports
+// errors, rather than exiting.
+func (app *admin) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(app.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(app.stderr, "Usage: snippetadmin %s [flags]\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// The findUser method looks up a user by email address.
+func (app *admin) findUser(ctx context.Context, users userStore, email string) (*models.User, error) {
+	if email == "" {
+		return nil, errors.New("the -email flag is required")
+	}
+	user, err := users.GetByEmail(ctx, email)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, fmt.Errorf("no user with email %s", email)
+	}
+	return user, err
+}
+
+// The checkPassword function applies the same rules as the signup form. If
+// password is empty a random one is generated and printed.
+func (app *admin) checkPassword(password string) (string, error) {
+	if password == "" {
+		b := make([]byte, 12)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+		fmt.Fprintf(app.stdout, "Generated password: %s\n", password)
+	}
+	if !validator.MinChars(password, 8) {
+		return "", errors.New("the password must be at least 8 characters long")
+	}
+	return password, nil
+}
+
+func (app *admin) migrate(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("migrate")
+	baseline := fs.Int("baseline", 0, "record migrations up to this version as applied without running them")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	applied, err := models.Migrate(ctx, app.db, *baseline)
+	for _, name := range applied {
+		fmt.Fprintf(app.stdout, "Applied %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintln(app.stdout, "Nothing to migrate.")
+	}
+	return nil
+}
+
+func (app *admin) stats(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("stats")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	s, err := models.GetStats(ctx, app.db)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users:\t%d\n", s.Users)
+	fmt.Fprintf(tw, "Disabled users:\t%d\n", s.DisabledUsers)
+	fmt.Fprintf(tw, "Snippets:\t%d\n", s.Snippets)
+	fmt.Fprintf(tw, "Expired snippets:\t%d\n", s.ExpiredSnippets)
+	fmt.Fprintf(tw, "Active sessions:\t%d\n", s.Sessions)
+	fmt.Fprintf(tw, "API tokens:\t%d\n", s.APITokens)
+	return tw.Flush()
+}
+
+func (app *admin) userCreate(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("user create")
+	name := fs.String("name", "", "display name")
+	email := fs.String("email", "", "email address")
+	password := fs.String("password", "", "password (generated if empty)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if !validator.NotBlank(*name) {
+		return errors.New("the -name flag is required")
+	}
+	if !validator.Matches(*email, validator.EmailRX) {
+		return errors.New("the -email flag must be a valid email address")
+	}
+	pw, err := app.checkPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.users()
+	if err != nil {
+		return err
+	}
+	defer users.CloseAll()
+
+	err = users.Insert(ctx, *name, *email, pw)
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		return fmt.Errorf("a user with email %s already exists", *email)
+	}
+	if err != nil {
+		return err
+	}
+	// Accounts created by an operator don't need to verify their address.
+	user, err := users.GetByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	err = users.SetVerified(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "Created user %s.\n", *email)
+	return nil
+}
+
+func (app *admin) userResetPassword(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("user reset-password")
+	email := fs.String("email", "", "email address of the user")
+	password := fs.String("password", "", "new password (generated if empty)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.users()
+	if err != nil {
+		return err
+	}
+	defer users.CloseAll()
+
+	user, err := app.findUser(ctx, users, *email)
+	if err != nil {
+		return err
+	}
+	pw, err := app.checkPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	err = users.SetPassword(ctx, user.ID, pw)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "Password reset for %s.\n", user.Email)
+	return nil
+}
+
+func (app *admin) userSetDisabled(ctx context.Context, args []string, disabled bool) error {
+	name := "user enable"
+	if disabled {
+		name = "user disable"
+	}
+	fs := app.newFlagSet(name)
+	email := fs.String("email", "", "email address of the user")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.users()
+	if err != nil {
+		return err
+	}
+	defer users.CloseAll()
+
+	user, err := app.findUser(ctx, users, *email)
+	if err != nil {
+		return err
+	}
+
+	err = users.SetDisabled(ctx, user.ID, disabled)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		fmt.Fprintf(app.stdout, "Disabled %s.\n", user.Email)
+	} else {
+		fmt.Fprintf(app.stdout, "Enabled %s.\n", user.Email)
+	}
+	return nil
+}
+
+func (app *admin) userUnlock(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("user unlock")
+	email := fs.String("email", "", "email address of the user")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.users()
+	if err != nil {
+		return err
+	}
+	defer users.CloseAll()
+
+	user, err := app.findUser(ctx, users, *email)
+	if err != nil {
+		return err
+	}
+
+	logins, err := app.logins()
+	if err != nil {
+		return err
+	}
+	defer logins.CloseAll()
+
+	n, err := logins.Unlock(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "Unlocked %s, cleared %d failed logins.\n", user.Email, n)
+	return nil
+}
+
+func (app *admin) userAttempts(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("user attempts")
+	email := fs.String("email", "", "email address the logins were attempted with")
+	limit := fs.Int("limit", 20, "maximum number of attempts to list")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Attempts are recorded for any email address, whether or not it has
+	// an account.
+	if *email == "" {
+		return errors.New("the -email flag is required")
+	}
+
+	logins, err := app.logins()
+	if err != nil {
+		return err
+	}
+	defer logins.CloseAll()
+
+	attempts, err := logins.List(ctx, *email, *limit)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tIP\tRESULT\tCLEARED")
+	for _, a := range attempts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", a.Created.UTC().Format(time.DateTime), a.IP, a.Result, a.Cleared)
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "%d attempts\n", len(attempts))
+	return nil
+}
+
+// The snippetFilter method registers the filter flags shared by the snippet
+// commands, and returns a function which builds the filter once the flags
+// have been parsed.
+func (app *admin) snippetFilter(fs *flag.FlagSet) func(ctx context.Context) (models.SnippetFilter, error) {
+	owner := fs.String("owner", "", "only snippets owned by the user with this email address")
+	olderThan := fs.Int("older-than", 0, "only snippets created more than this many days ago")
+	expired := fs.Bool("expired", false, "only expired snippets")
+
+	return func(ctx context.Context) (models.SnippetFilter, error) {
+		f := models.SnippetFilter{ExpiredOnly: *expired}
+		if *olderThan < 0 {
+			return f, errors.New("the -older-than flag must not be negative")
+		}
+		if *olderThan > 0 {
+			f.CreatedBefore = time.Now().AddDate(0, 0, -*olderThan)
+		}
+		if *owner != "" {
+			users, err := app.users()
+			if err != nil {
+				return f, err
+			}
+			defer users.CloseAll()
+
+			user, err := app.findUser(ctx, users, *owner)
+			if err != nil {
+				return f, err
+			}
+			f.UserID = user.ID
+		}
+		return f, nil
+	}
+}
+
+func (app *admin) snippetList(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("snippet list")
+	filter := app.snippetFilter(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	f, err := filter(ctx)
+	if err != nil {
+		return err
+	}
+
+	snippets, err := models.NewSnippetModel(app.db)
+	if err != nil {
+		return err
+	}
+	defer snippets.CloseAll()
+
+	found, err := snippets.Find(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tOWNER\tTITLE\tVISIBILITY\tCREATED\tEXPIRES")
+	for _, s := range found {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", s.ID, s.UserID, s.Title, s.Visibility,
+			s.Created.UTC().Format(time.DateOnly), s.Expires.UTC().Format(time.DateOnly))
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "%d snippets\n", len(found))
+	return nil
+}
+
+func (app *admin) snippetPurge(ctx context.Context, args []string) error {
+	fs := app.newFlagSet("snippet purge")
+	filter := app.snippetFilter(fs)
+	yes := fs.Bool("yes", false, "actually delete the snippets, rather than only counting them")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	f, err := filter(ctx)
+	if err != nil {
+		return err
+	}
+	if f == (models.SnippetFilter{}) {
+		return errors.New("refusing to purge every snippet, use -owner, -older-than or -expired")
+	}
+
+	snippets, err := models.NewSnippetModel(app.db)
+	if err != nil {
+		return err
+	}
+	defer snippets.CloseAll()
+
+	if !*yes {
+		found, err := snippets.Find(ctx, f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(app.stdout, "%d snippets would be deleted, run again with -yes to delete them.\n", len(found))
+		return nil
+	}
+
+	n, err := snippets.Purge(ctx, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.stdout, "Deleted %d snippets.\n", n)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The fakeUsers type is a userStore holding users in memory.
+type fakeUsers struct {
+	users map[string]*models.User
+}
+
+func (m *fakeUsers) Insert(ctx context.Context, name, email, password string) error {
+	if _, ok := m.users[email]; ok {
+		return models.ErrDuplicateEmail
+	}
+	m.users[email] = &models.User{ID: len(m.users) + 1, Name: name, Email: email}
+	return nil
+}
+
+func (m *fakeUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, ok := m.users[email]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return user, nil
+}
+
+func (m *fakeUsers) byID(id int) *models.User {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m *fakeUsers) SetPassword(ctx context.Context, id int, password string) error {
+	return nil
+}
+
+func (m *fakeUsers) SetVerified(ctx context.Context, id int) error {
+	m.byID(id).Verified = true
+	return nil
+}
+
+func (m *fakeUsers) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	m.byID(id).Disabled = disabled
+	return nil
+}
+
+func (m *fakeUsers) CloseAll() error {
+	return nil
+}
+
+// The fakeLogins type is a loginStore which counts failed logins by email
+// address.
+type fakeLogins struct {
+	failures map[string]int64
+}
+
+func (m *fakeLogins) Unlock(ctx context.Context, email string) (int64, error) {
+	n := m.failures[email]
+	delete(m.failures, email)
+	return n, nil
+}
+
+func (m *fakeLogins) List(ctx context.Context, email string, limit int) ([]*models.LoginAttempt, error) {
+	return []*models.LoginAttempt{}, nil
+}
+
+func (m *fakeLogins) CloseAll() error {
+	return nil
+}
+
+// The newTestAdmin function returns an admin whose models are fakes, with
+// the user bob@example.com, who has 3 failed logins.
+func newTestAdmin(t *testing.T) (*admin, *fakeUsers, *fakeLogins, *bytes.Buffer) {
+	users := &fakeUsers{users: map[string]*models.User{
+		"bob@example.com": {ID: 1, Name: "Bob", Email: "bob@example.com"},
+	}}
+	logins := &fakeLogins{failures: map[string]int64{"bob@example.com": 3}}
+	var stdout bytes.Buffer
+	app := &admin{
+		users:  func() (userStore, error) { return users, nil },
+		logins: func() (loginStore, error) { return logins, nil },
+		stdout: &stdout,
+		stderr: io.Discard,
+	}
+	return app, users, logins, &stdout
+}
+
+func TestUserSetDisabled(t *testing.T) {
+	app, users, _, stdout := newTestAdmin(t)
+	bob := users.users["bob@example.com"]
+
+	err := app.run(context.Background(), []string{"user", "disable", "-email", "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bob.Disabled, true)
+	assert.Equal(t, stdout.String(), "Disabled bob@example.com.\n")
+
+	stdout.Reset()
+	err = app.run(context.Background(), []string{"user", "enable", "-email", "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bob.Disabled, false)
+	assert.Equal(t, stdout.String(), "Enabled bob@example.com.\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "Unknown user",
+			args:    []string{"user", "disable", "-email", "alice@example.com"},
+			wantErr: "no user with email alice@example.com",
+		},
+		{
+			name:    "No email",
+			args:    []string{"user", "enable"},
+			wantErr: "the -email flag is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := app.run(context.Background(), tt.args)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			assert.Equal(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestUserUnlock(t *testing.T) {
+	app, _, logins, stdout := newTestAdmin(t)
+
+	err := app.run(context.Background(), []string{"user", "unlock", "-email", "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stdout.String(), "Unlocked bob@example.com, cleared 3 failed logins.\n")
+	assert.Equal(t, logins.failures["bob@example.com"], int64(0))
+
+	// Unlocking again finds nothing to clear.
+	stdout.Reset()
+	err = app.run(context.Background(), []string{"user", "unlock", "-email", "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stdout.String(), "Unlocked bob@example.com, cleared 0 failed logins.\n")
+
+	err = app.run(context.Background(), []string{"user", "unlock", "-email", "alice@example.com"})
+	if err == nil || err.Error() != "no user with email alice@example.com" {
+		t.Errorf("got error %v; want an unknown user error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+	"github.com.scottyfionnghall.snippetbox/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Define a snippetInput struct to hold the JSON body of create and update
+// requests, along with any validation errors.
+type snippetInput struct {
+	Title               string            `json:"title"`
+	Files               []snippetFileForm `json:"files"`
+	Expires             int               `json:"expires"`
+	Visibility          string            `json:"visibility"`
+	validator.Validator `json:"-"`
+}
+
+// Define a paginationMetadata struct describing the page of results returned
+// by list endpoints.
+type paginationMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// The newPaginationMetadata function calculates the metadata for a page of
+// results. If there are no records at all, an empty metadata struct is
+// returned.
+func newPaginationMetadata(totalRecords, page, pageSize int) paginationMetadata {
+	if totalRecords == 0 {
+		return paginationMetadata{}
+	}
+	return paginationMetadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
+
+// The PrevPage and NextPage methods return the neighbouring page numbers for
+// pagination links in templates, or 0 if there is no such page.
+func (p paginationMetadata) PrevPage() int {
+	if p.CurrentPage <= p.FirstPage {
+		return 0
+	}
+	return p.CurrentPage - 1
+}
+
+func (p paginationMetadata) NextPage() int {
+	if p.CurrentPage >= p.LastPage {
+		return 0
+	}
+	return p.CurrentPage + 1
+}
+
+// The readPagination helper reads the page and page_size query string
+// parameters, falling back to the defaults and recording any errors in v.
+func readPagination(r *http.Request, v *validator.Validator) (page, pageSize int) {
+	readInt := func(key string, defaultValue int) int {
+		s := r.URL.Query().Get(key)
+		if s == "" {
+			return defaultValue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			v.AddFieldError(key, "This field must be an integer value")
+			return defaultValue
+		}
+		return i
+	}
+
+	page = readInt("page", 1)
+	pageSize = readInt("page_size", 20)
+
+	v.CheckField(page > 0, "page", "This field must be greater than zero")
+	v.CheckField(page <= 10_000_000, "page", "This field must be a maximum of 10 million")
+	v.CheckField(pageSize > 0, "page_size", "This field must be greater than zero")
+	v.CheckField(pageSize <= 100, "page_size", "This field must be a maximum of 100")
+	return page, pageSize
+}
+
+// The readIDParam helper returns the :id route parameter, or 0 if it isn't a
+// positive integer.
+func readIDParam(r *http.Request) int {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0
+	}
+	return id
+}
+
+// This handler returns a page of the latest public snippets as JSON. With
+// ?mine=true it returns all of the authenticated user's snippets instead.
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	var v validator.Validator
+	page, pageSize := readPagination(r, &v)
+	if !v.Valid() {
+		app.validationProblem(w, r, v)
+		return
+	}
+
+	userID := 0
+	if r.URL.Query().Get("mine") == "true" {
+		if !app.isAuthenticated(r) {
+			app.invalidCredentials(w, r)
+			return
+		}
+		userID = app.authenticatedUserID(r)
+	}
+
+	snippets, total, err := app.snippets.List(r.Context(), userID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{
+		"snippets": snippets,
+		"metadata": newPaginationMetadata(total, page, pageSize),
+	}, nil)
+}
+
+// This handler returns a single snippet as JSON.
+func (app *application) apiSnippetGet(w http.ResponseWriter, r *http.Request) {
+	id := readIDParam(r)
+	if id == 0 {
+		app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	if !snippet.CanView(app.authenticatedUserID(r)) {
+		app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": snippet}, nil)
+}
+
+// The readSnippetInput helper decodes and validates the body of a create or
+// update request. If it returns false, an error response has been sent.
+func (app *application) readSnippetInput(w http.ResponseWriter, r *http.Request) (snippetInput, bool) {
+	var input snippetInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.problemResponse(w, r, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+
+	if input.Visibility == "" {
+		input.Visibility = models.VisibilityPublic
+	}
+
+	checkSnippet(&input.Validator, input.Title, input.Files, input.Expires, input.Visibility)
+	if !input.Valid() {
+		app.validationProblem(w, r, input.Validator)
+		return input, false
+	}
+	return input, true
+}
+
+// This handler creates a new snippet owned by the authenticated user.
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	input, ok := app.readSnippetInput(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.snippets.Insert(r.Context(), app.authenticatedUserID(r),
+		input.Title, toModelFiles(input.Files), input.Expires, input.Visibility)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": {fmt.Sprintf("/api/v1/snippets/%d", id)}}
+	app.writeJSON(w, r, http.StatusCreated, envelope{"snippet": snippet}, headers)
+}
+
+// The ownedSnippet helper fetches the snippet from the :id route parameter and
+// checks that it belongs to the authenticated user. If it doesn't, an error
+// response has already been sent and nil is returned.
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	id := readIDParam(r)
+	if id == 0 {
+		app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		return nil
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return nil
+	}
+
+	if snippet.UserID == 0 || snippet.UserID != app.authenticatedUserID(r) {
+		app.problemResponse(w, r, http.StatusForbidden, "You can only change your own snippets.")
+		return nil
+	}
+	return snippet
+}
+
+// This handler replaces the title, content and expiry of a snippet.
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet := app.ownedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	input, ok := app.readSnippetInput(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Update(r.Context(), snippet.ID,
+		input.Title, toModelFiles(input.Files), input.Expires, input.Visibility)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.problemResponse(w, r, http.StatusNotFound, "The requested snippet could not be found.")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	snippet, err = app.snippets.Get(r.Context(), snippet.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": snippet}, nil)
+}
+
+// This handler deletes a snippet owned by the authenticated user.
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet := app.ownedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.snippets.Delete(r.Context(), snippet.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
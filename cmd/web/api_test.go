@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+// basicAuth returns the headers for a request authenticated as the given
+// mock user.
+func basicAuth(email, password string) http.Header {
+	creds := base64.StdEncoding.EncodeToString([]byte(email + ":" + password))
+	return http.Header{"Authorization": {"Basic " + creds}}
+}
+
+func TestAPISnippetList(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Default page",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"total_records": 1`,
+		},
+		{
+			name:     "Page past the end",
+			urlPath:  "/api/v1/snippets?page=2&page_size=1",
+			wantCode: http.StatusOK,
+			wantBody: `"snippets": []`,
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/api/v1/snippets?page=0",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"page": "This field must be greater than zero"`,
+		},
+		{
+			name:     "Page size too large",
+			urlPath:  "/api/v1/snippets?page_size=1000",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"page_size"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetListMine(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/api/v1/snippets?mine=true")
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	header := http.Header{"Authorization": {"Bearer sbx_read"}}
+	code, _, body := ts.do(t, http.MethodGet, "/api/v1/snippets?mine=true", nil, header)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"visibility": "private"`)
+	assert.StringContains(t, body, `"total_records": 2`)
+}
+
+func TestAPISnippetGet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/api/v1/snippets/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"content": "Test content..."`)
+
+	code, header, body := ts.get(t, "/api/v1/snippets/2")
+	assert.Equal(t, code, http.StatusNotFound)
+	assert.Equal(t, header.Get("Content-Type"), "application/problem+json")
+	assert.StringContains(t, body, `"status": 404`)
+
+	// Private snippets are only visible to their owner.
+	code, _, _ = ts.get(t, "/api/v1/snippets/3")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.do(t, http.MethodGet, "/api/v1/snippets/3", nil, basicAuth("other@example.com", "pa$$word"))
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.do(t, http.MethodGet, "/api/v1/snippets/3", nil, basicAuth("test@example.com", "pa$$word"))
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			body:     `{"title": "Test title", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 7}`,
+			wantCode: http.StatusCreated,
+			wantBody: `"snippet"`,
+		},
+		{
+			name:     "Unauthenticated",
+			body:     `{"title": "Test title", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Wrong password",
+			header:   basicAuth("test@example.com", "wrong"),
+			body:     `{"title": "Test title", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Invalid fields",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			body:     `{"title": "", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 2}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"expires": "This field must equal 1,7 or 365"`,
+		},
+		{
+			name:     "Unknown field",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			body:     `{"title": "Test title", "owner": 2}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `unknown field \"owner\"`,
+		},
+		{
+			name:     "Badly-formed JSON",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			body:     `{"title": `,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets", strings.NewReader(tt.body), tt.header)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetUpdateDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const body = `{"title": "New title", "files": [{"name": "new.txt", "content": "New content"}], "expires": 1}`
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		header   http.Header
+		wantCode int
+	}{
+		{
+			name:     "Update own snippet",
+			method:   http.MethodPut,
+			urlPath:  "/api/v1/snippets/1",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Update someone else's snippet",
+			method:   http.MethodPut,
+			urlPath:  "/api/v1/snippets/1",
+			header:   basicAuth("other@example.com", "pa$$word"),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Update missing snippet",
+			method:   http.MethodPut,
+			urlPath:  "/api/v1/snippets/2",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Update snippet which has expired",
+			method:   http.MethodPut,
+			urlPath:  "/api/v1/snippets/5",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Delete unauthenticated",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Delete someone else's snippet",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			header:   basicAuth("other@example.com", "pa$$word"),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Delete own snippet",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			header:   basicAuth("test@example.com", "pa$$word"),
+			wantCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.do(t, tt.method, tt.urlPath, strings.NewReader(body), tt.header)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAPIBasicAuthTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	enableTwoFactor(t, app, 1)
+
+	// The password alone isn't enough for an account with two-factor
+	// authentication, but its API tokens still work.
+	code, header, body := ts.do(t, http.MethodGet, "/api/v1/snippets/1", nil, basicAuth("test@example.com", "pa$$word"))
+	assert.Equal(t, code, http.StatusUnauthorized)
+	assert.Equal(t, header.Get("WWW-Authenticate"), `Bearer realm="snippetbox"`)
+	assert.StringContains(t, body, "Use an API token instead of a password.")
+
+	code, _, _ = ts.do(t, http.MethodGet, "/api/v1/snippets/1", nil, http.Header{"Authorization": {"Bearer sbx_read"}})
+	assert.Equal(t, code, http.StatusOK)
+
+	code, _, _ = ts.do(t, http.MethodGet, "/api/v1/snippets/1", nil, basicAuth("other@example.com", "pa$$word"))
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestAPIBearerToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const body = `{"title": "Test title", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 7}`
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		wantCode int
+	}{
+		{
+			name:     "Read with read scope",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_read",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Create with read scope",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_read",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Delete with read scope",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_read",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Create with write scope",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_full",
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Delete with delete scope",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_full",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Revoked token",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_revoked",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Disabled user's token",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_disabled",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Authorization": {"Bearer " + tt.token}}
+			code, _, _ := ts.do(t, tt.method, tt.urlPath, strings.NewReader(body), header)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
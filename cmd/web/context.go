@@ -1,5 +1,30 @@
 package main
 
+import "context"
+
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey     = contextKey("isAuthenticated")
+	authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+	apiTokenContextKey            = contextKey("apiToken")
+	requestIDContextKey           = contextKey("requestID")
+	requestInfoContextKey         = contextKey("requestInfo")
+)
+
+// requestInfo holds request-scoped details which are filled in by inner
+// middleware and handlers, but read by the outer logRequest middleware once
+// the response has been written.
+type requestInfo struct {
+	userID int
+}
+
+// Return the request ID stored in the context by the requestID middleware,
+// or an empty string if there is none.
+func requestIDFromContext(ctx context.Context) string {
+	id, ok := ctx.Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com.scottyfionnghall.snippetbox/internal/directory"
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The isLocalUser method reports whether the user with the given email
+// address logs in with a local password. Everyone does unless a directory
+// is configured, and then only the break-glass accounts listed in
+// -ldap-local-users do.
+func (app *application) isLocalUser(email string) bool {
+	if app.directory == nil {
+		return true
+	}
+	for _, local := range app.localUsers {
+		if strings.EqualFold(email, local) {
+			return true
+		}
+	}
+	return false
+}
+
+// The checkCredentials method returns the ID of the user with the given
+// email address and password, checking the password against the directory
+// if one is configured. Directory users are linked to local accounts by
+// email address, and an account is created on their first login. If the
+// credentials are wrong, or the user isn't allowed to log in,
+// ErrInvalidCredentials is returned.
+func (app *application) checkCredentials(ctx context.Context, email, password string) (int, error) {
+	if app.isLocalUser(email) {
+		return app.users.Authenticate(ctx, email, password)
+	}
+
+	entry, err := app.directory.Authenticate(ctx, email, password)
+	if err != nil {
+		if errors.Is(err, directory.ErrInvalidCredentials) || errors.Is(err, directory.ErrNotAllowed) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	if entry.Email == "" {
+		entry.Email = email
+	}
+
+	id, err := app.externalUser(ctx, entry.Name, entry.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	// The directory is the source of truth for the user's name.
+	name := truncateName(entry.Name)
+	if name != "" {
+		user, err := app.users.Get(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if user.Name != name {
+			err = app.users.UpdateName(ctx, id, name)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return id, nil
+}
+
+// The externalUser helper returns the ID of the user with the given email
+// address, whose identity has been checked by a directory or identity
+// provider. A new user is created the first time they log in, and the
+// address of an existing user is marked as verified. If the user has been
+// disabled, ErrNoRecord is returned.
+func (app *application) externalUser(ctx context.Context, name, email string) (int, error) {
+	user, err := app.users.GetByEmail(ctx, email)
+	if errors.Is(err, models.ErrNoRecord) {
+		name = truncateName(name)
+		if name == "" {
+			name, _, _ = strings.Cut(email, "@")
+		}
+		return app.users.InsertExternal(ctx, name, email)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if user.Disabled {
+		return 0, models.ErrNoRecord
+	}
+	if !user.Verified {
+		err = app.users.SetVerified(ctx, user.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return user.ID, nil
+}
+
+// The truncateName function trims a name from a directory or identity
+// provider to fit the users table.
+func truncateName(name string) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	return name
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/directory"
+	"github.com.scottyfionnghall.snippetbox/internal/ldaptest"
+)
+
+const (
+	testServiceDN = "cn=snippetbox,ou=services,dc=example,dc=com"
+	testGroupDN   = "cn=writers,ou=groups,dc=example,dc=com"
+)
+
+func newTestDirectory(t *testing.T) *ldaptest.Server {
+	person := func(uid, name, email string, groups ...string) *ldaptest.Entry {
+		return &ldaptest.Entry{
+			DN:       "uid=" + uid + ",ou=people,dc=example,dc=com",
+			Password: "directory-pw",
+			Attributes: map[string][]string{
+				"uid":      {uid},
+				"cn":       {name},
+				"mail":     {email},
+				"memberOf": groups,
+			},
+		}
+	}
+	s, err := ldaptest.NewServer(
+		&ldaptest.Entry{DN: testServiceDN, Password: "service"},
+		person("test", "Test Person", "test@example.com", testGroupDN),
+		person("new", "New Person", "new@example.com", testGroupDN),
+		person("outsider", "Outsider", "outsider@example.com"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDirectoryLogin(t *testing.T) {
+	dir := newTestDirectory(t)
+	defer dir.Close()
+
+	app := newTestApplication(t)
+	app.directory = &directory.Directory{
+		URL:          dir.URL,
+		BindDN:       testServiceDN,
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		Filter:       "(mail=%s)",
+		NameAttr:     "cn",
+		EmailAttr:    "mail",
+		GroupDN:      testGroupDN,
+	}
+	app.localUsers = []string{"Other@example.com"}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	logIn := func(email, password string) int {
+		code, _, _ := ts.tryLogin(t, email, password)
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantCode int
+	}{
+		{
+			name:     "Directory user",
+			email:    "test@example.com",
+			password: "directory-pw",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Local password of directory user",
+			email:    "test@example.com",
+			password: "pa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "New user",
+			email:    "new@example.com",
+			password: "directory-pw",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Not a group member",
+			email:    "outsider@example.com",
+			password: "directory-pw",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Break-glass user",
+			email:    "other@example.com",
+			password: "pa$$word",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := logIn(tt.email, tt.password)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	t.Run("Account pages", func(t *testing.T) {
+		// Accounts come from the directory, so there is no signup.
+		code, _, _ := ts.get(t, "/user/signup")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		// Directory users can't change their password here.
+		logIn("test@example.com", "directory-pw")
+		code, header, _ := ts.get(t, "/account/password")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account")
+		_, _, body := ts.get(t, "/account")
+		assert.StringContains(t, body, "managed by your organisation")
+
+		// Break-glass users can.
+		logIn("other@example.com", "pa$$word")
+		code, _, _ = ts.get(t, "/account/password")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Directory down", func(t *testing.T) {
+		dir.Close()
+
+		code := logIn("other@example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// Directory users don't fall back to their local password.
+		code = logIn("test@example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusInternalServerError)
+	})
+}
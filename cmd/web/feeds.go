@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The number of snippets included in a feed.
+const feedSize = 20
+
+// Define a feed type holding everything needed to write a feed of snippets,
+// in either the Atom or the RSS format.
+type feed struct {
+	Title    string
+	Author   string
+	Link     string
+	Self     string
+	Updated  time.Time
+	Snippets []*models.Snippet
+	baseURL  string
+}
+
+// Types for the Atom (RFC 4287) representation of a feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Summary   atomSummary `xml:"summary"`
+}
+
+type atomSummary struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Types for the RSS 2.0 representation of a feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Text        string `xml:",chardata"`
+}
+
+func (f *feed) snippetURL(s *models.Snippet) string {
+	return fmt.Sprintf("%s/snippet/view/%d", f.baseURL, s.ID)
+}
+
+func (f *feed) atom() any {
+	out := atomFeed{
+		Title:   f.Title,
+		ID:      f.baseURL + f.Self,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.baseURL + f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.baseURL + f.Link},
+		},
+		Author:  atomAuthor{Name: f.Author},
+		Entries: []atomEntry{},
+	}
+	for _, s := range f.Snippets {
+		out.Entries = append(out.Entries, atomEntry{
+			Title:     s.Title,
+			ID:        f.snippetURL(s),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: f.snippetURL(s)},
+			Published: s.Created.UTC().Format(time.RFC3339),
+			Updated:   s.Updated.UTC().Format(time.RFC3339),
+			Summary:   atomSummary{Type: "text", Text: s.Excerpt},
+		})
+	}
+	return out
+}
+
+func (f *feed) rss() any {
+	out := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.baseURL + f.Link,
+			Description: f.Title,
+			Items:       []rssItem{},
+		},
+	}
+	if !f.Updated.IsZero() {
+		out.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, s := range f.Snippets {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        f.snippetURL(s),
+			GUID:        rssGUID{IsPermaLink: true, Text: f.snippetURL(s)},
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: s.Excerpt,
+		})
+	}
+	return out
+}
+
+// The newFeed helper builds a feed of the latest public snippets of a user,
+// or of everyone if userID is 0. The feed is updated whenever one of its
+// snippets is.
+func (app *application) newFeed(r *http.Request, title, author, link, self string, userID int) (*feed, error) {
+	snippets, _, err := app.snippets.Public(r.Context(), userID, feedSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &feed{
+		Title:    title,
+		Author:   author,
+		Link:     link,
+		Self:     self,
+		Snippets: snippets,
+		// The server only listens on HTTPS.
+		baseURL: "https://" + r.Host,
+	}
+	for _, s := range snippets {
+		if s.Updated.After(f.Updated) {
+			f.Updated = s.Updated
+		}
+	}
+	return f, nil
+}
+
+// The writeFeed helper encodes a feed as Atom or RSS and writes it with
+// ETag and Last-Modified headers. http.ServeContent answers conditional
+// requests with 304 Not Modified.
+func (app *application) writeFeed(w http.ResponseWriter, r *http.Request, f *feed, format string) {
+	var doc any
+	var contentType string
+	switch format {
+	case "atom":
+		doc, contentType = f.atom(), "application/atom+xml; charset=utf-8"
+	case "rss":
+		doc, contentType = f.rss(), "application/rss+xml; charset=utf-8"
+	default:
+		app.notFound(w)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(buf.Bytes()))
+}
+
+// This handler returns a feed of the latest public snippets.
+func (app *application) feedLatest(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := app.newFeed(r, "Snippetbox", "Snippetbox", "/", "/feed."+format, 0)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.writeFeed(w, r, f, format)
+	}
+}
+
+// This handler returns a feed of the latest public snippets of one user.
+func (app *application) feedUser(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := readIDParam(r)
+		if id == 0 {
+			app.notFound(w)
+			return
+		}
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		if user.Disabled {
+			app.notFound(w)
+			return
+		}
+
+		f, err := app.newFeed(r, user.Name+"'s snippets - Snippetbox", user.Name,
+			fmt.Sprintf("/users/%d", user.ID), fmt.Sprintf("/users/%d/feed.%s", user.ID, format), user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.writeFeed(w, r, f, format)
+	}
+}
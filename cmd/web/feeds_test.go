@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestFeeds(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantContentType string
+		wantBody        []string
+	}{
+		{
+			name:            "Atom",
+			urlPath:         "/feed.atom",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/atom+xml; charset=utf-8",
+			wantBody: []string{
+				`<feed xmlns="http://www.w3.org/2005/Atom">`,
+				"<title>Test title</title>",
+				"<title>Forked title</title>",
+				`<summary type="text">Test content...</summary>`,
+				"/snippet/view/1</id>",
+			},
+		},
+		{
+			name:            "RSS",
+			urlPath:         "/feed.rss",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/rss+xml; charset=utf-8",
+			wantBody: []string{
+				`<rss version="2.0">`,
+				"<title>Test title</title>",
+				"<description>Test content...</description>",
+			},
+		},
+		{
+			name:            "User",
+			urlPath:         "/users/2/feed.atom",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/atom+xml; charset=utf-8",
+			wantBody: []string{
+				"<name>Other</name>",
+				"<title>Forked title</title>",
+			},
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/users/99/feed.atom",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid user ID",
+			urlPath:  "/users/foo/feed.rss",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantContentType != "" {
+				assert.Equal(t, header.Get("Content-Type"), tt.wantContentType)
+			}
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
+
+func TestFeedConditionalRequests(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/feed.atom")
+	assert.Equal(t, code, http.StatusOK)
+	etag := header.Get("ETag")
+	lastModified := header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q; want both set", etag, lastModified)
+	}
+
+	code, _, _ = ts.do(t, http.MethodGet, "/feed.atom", nil, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, code, http.StatusNotModified)
+
+	code, _, _ = ts.do(t, http.MethodGet, "/feed.atom", nil, http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, code, http.StatusNotModified)
+
+	code, _, _ = ts.do(t, http.MethodGet, "/feed.atom", nil, http.Header{"If-None-Match": {`"stale"`}})
+	assert.Equal(t, code, http.StatusOK)
+}
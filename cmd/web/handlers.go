@@ -1,11 +1,19 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com.scottyfionnghall.snippetbox/internal/mailer"
 	"github.com.scottyfionnghall.snippetbox/internal/models"
 	"github.com.scottyfionnghall.snippetbox/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -15,12 +23,45 @@ import (
 // and validation errors from fields. All fields must be exported to be used
 // by html/templates
 type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	Expires             int               `form:"expires"`
+	Visibility          string            `form:"visibility"`
 	validator.Validator `form:"-"`
 }
 
+// Define a snippetFileForm struct for one file of a snippet. It is used both
+// by the HTML form, as files[0].name etc., and by the JSON API.
+type snippetFileForm struct {
+	Name     string `form:"name" json:"name"`
+	Language string `form:"language" json:"language"`
+	Content  string `form:"content" json:"content"`
+}
+
+// The languages which can be chosen for a snippet file.
+var languages = []string{
+	"plaintext", "c", "cpp", "css", "dockerfile", "go", "html", "ini", "java",
+	"javascript", "json", "makefile", "markdown", "python", "ruby", "rust",
+	"shell", "sql", "toml", "typescript", "xml", "yaml",
+}
+
+// The maximum number of files in a single snippet.
+const maxSnippetFiles = 20
+
+// The toModelFiles function converts submitted files to the model type. Files
+// without a language are treated as plain text.
+func toModelFiles(files []snippetFileForm) []*models.SnippetFile {
+	out := make([]*models.SnippetFile, 0, len(files))
+	for _, f := range files {
+		language := f.Language
+		if language == "" {
+			language = "plaintext"
+		}
+		out = append(out, &models.SnippetFile{Name: f.Name, Language: language, Content: f.Content})
+	}
+	return out
+}
+
 type userSignupForm struct {
 	Name                string `form:"name"`
 	Email               string `form:"email"`
@@ -31,6 +72,176 @@ type userSignupForm struct {
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	Remember            bool   `form:"remember"`
+	validator.Validator `form:"-"`
+}
+
+// The checkSnippet function runs the validation checks shared by the HTML
+// form and the JSON API when creating or updating a snippet.
+func checkSnippet(v *validator.Validator, title string, files []snippetFileForm, expires int, visibility string) {
+	v.CheckField(validator.NotBlank(title), "title",
+		"This field cannot be blank")
+	v.CheckField(validator.MaxChars(title, 100), "title",
+		"This field cannot be more than 100 characters long")
+	v.CheckField(len(files) > 0, "files",
+		"A snippet must contain at least one file")
+	v.CheckField(len(files) <= maxSnippetFiles, "files",
+		fmt.Sprintf("A snippet cannot contain more than %d files", maxSnippetFiles))
+	// Errors for each file are keyed by its position, e.g. "files[0]". Names
+	// are compared ignoring case, like the database's unique key does.
+	seen := map[string]bool{}
+	for i, f := range files {
+		key := fmt.Sprintf("files[%d]", i)
+		v.CheckField(validator.NotBlank(f.Name), key,
+			"The file name cannot be blank")
+		v.CheckField(validator.MaxChars(f.Name, 255), key,
+			"The file name cannot be more than 255 characters long")
+		v.CheckField(!strings.ContainsAny(f.Name, `/\`) && f.Name != "." && f.Name != "..", key,
+			"The file name cannot contain slashes")
+		v.CheckField(!seen[strings.ToLower(f.Name)], key,
+			"The file name is already used by another file")
+		v.CheckField(f.Language == "" || validator.PermitedValue(f.Language, languages...), key,
+			"The language is not supported")
+		v.CheckField(validator.NotBlank(f.Content), key,
+			"The file content cannot be blank")
+		seen[strings.ToLower(f.Name)] = true
+	}
+	v.CheckField(validator.PermitedValue(expires, 1, 7, 365), "expires",
+		"This field must equal 1,7 or 365")
+	v.CheckField(validator.PermitedValue(visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate),
+		"visibility", "This field must equal public, unlisted or private")
+}
+
+// Define a commentForm struct for a new comment or reply. File and Line are
+// optional and bind the comment to a line of one of the snippet's files.
+type commentForm struct {
+	Body                string `form:"body"`
+	File                string `form:"file"`
+	Line                int    `form:"line"`
+	ParentID            int    `form:"parent_id"`
+	validator.Validator `form:"-"`
+}
+
+// The maximum length of a comment, in characters.
+const maxCommentChars = 2000
+
+// The checkComment function validates a comment on snippet. The parent is
+// the comment being replied to, or nil for a new thread.
+func checkComment(v *validator.Validator, form *commentForm, snippet *models.Snippet, parent *models.Comment) {
+	v.CheckField(validator.NotBlank(form.Body), "body",
+		"This field cannot be blank")
+	v.CheckField(validator.MaxChars(form.Body, maxCommentChars), "body",
+		fmt.Sprintf("This field cannot be more than %d characters long", maxCommentChars))
+	if form.File != "" || form.Line != 0 {
+		file := snippet.File(form.File)
+		v.CheckField(file != nil, "line",
+			"The file does not exist in this snippet")
+		if file != nil {
+			v.CheckField(validator.Between(form.Line, 1, len(splitLines(file.Content))), "line",
+				"The line does not exist in this file")
+		}
+	}
+	if form.ParentID != 0 {
+		v.CheckField(parent != nil && parent.SnippetID == snippet.ID, "parent_id",
+			"The comment you replied to does not exist")
+	}
+}
+
+// Define a commentRow type for one comment on the snippet page. Threads are
+// flattened into rows, with Depth giving the level of nesting.
+type commentRow struct {
+	*models.Comment
+	Depth     int
+	CanDelete bool
+}
+
+// The maximum nesting shown on the snippet page. Deeper replies are shown at
+// this depth.
+const maxCommentDepth = 4
+
+// The flattenComments function appends the comment threads to rows in
+// display order. A comment can be deleted by its author and by the owner of
+// the snippet.
+func flattenComments(threads []*models.Comment, snippet *models.Snippet, userID, depth int, rows []commentRow) []commentRow {
+	for _, c := range threads {
+		rows = append(rows, commentRow{
+			Comment:   c,
+			Depth:     min(depth, maxCommentDepth),
+			CanDelete: userID != 0 && (c.UserID == userID || snippet.UserID == userID),
+		})
+		rows = flattenComments(c.Replies, snippet, userID, depth+1, rows)
+	}
+	return rows
+}
+
+// Define a profileForm struct for the profile settings page. The avatar is
+// uploaded as a file and isn't part of the decoded form.
+type profileForm struct {
+	Bio                 string `form:"bio"`
+	RemoveAvatar        bool   `form:"remove_avatar"`
+	validator.Validator `form:"-"`
+}
+
+// Define form structs for the account settings pages. Every change has to be
+// confirmed with the user's current password.
+type accountNameForm struct {
+	Name                string `form:"name"`
+	CurrentPassword     string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+type accountEmailForm struct {
+	Email               string `form:"email"`
+	CurrentPassword     string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+type accountPasswordForm struct {
+	CurrentPassword         string `form:"current_password"`
+	NewPassword             string `form:"new_password"`
+	NewPasswordConfirmation string `form:"new_password_confirmation"`
+	validator.Validator     `form:"-"`
+}
+
+// Define form structs for requesting a password reset link and for choosing
+// a new password. The token comes from the link in the email.
+type forgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type resetPasswordForm struct {
+	Token                string `form:"token"`
+	Password             string `form:"password"`
+	PasswordConfirmation string `form:"password_confirmation"`
+	validator.Validator  `form:"-"`
+}
+
+// How long a password reset link can be used for.
+const resetTokenTTL = time.Hour
+
+// Email verification links are signed rather than stored. They can be used
+// for a day, and a new one can be asked for every few minutes.
+const (
+	verifyPurpose        = "verify-email"
+	verifyTokenTTL       = 24 * time.Hour
+	verifyResendInterval = 5 * time.Minute
+)
+
+// Limits for profile details. Avatars must be PNG, JPEG, GIF or WebP images.
+// The whole profile form may be a little larger than the avatar, to leave
+// room for the other fields.
+const (
+	maxBioChars        = 1000
+	maxAvatarSize      = 256 << 10
+	maxProfileFormSize = maxAvatarSize + 32<<10
+)
+
+var avatarTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type apiTokenCreateForm struct {
+	Name                string   `form:"name"`
+	Scopes              []string `form:"scopes"`
 	validator.Validator `form:"-"`
 }
 
@@ -38,43 +249,325 @@ type userLoginForm struct {
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Check if the current request URL path exactly matches "/".
 
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
-	app.render(w, http.StatusOK, "home.html", data)
+	app.render(w, r, http.StatusOK, "home.html", data)
 }
 
-// This handler shows user particular snippet based on the passed ID.
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+// The viewableSnippet helper fetches the snippet from the :id route parameter
+// and checks that the current user may see it. If not, a 404 response has
+// already been sent and nil is returned.
+func (app *application) viewableSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
 	// httprouter extracts all parameters passed in the request in a form
 	// of a slice
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
 		app.notFound(w)
-		return
+		return nil
 	}
 	// Use the SnippetModel object's Get method to retrieve the data for a
 	// specific record based on its ID. If no matching record is found,
 	// return a 404 Not Found response.
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
+		return nil
+	}
+	// Private snippets are only shown to their owner, everyone else gets
+	// the same 404 as for a snippet that doesn't exist.
+	if !snippet.CanView(app.authenticatedUserID(r)) {
+		app.notFound(w)
+		return nil
+	}
+	return snippet
+}
+
+// This handler shows user particular snippet based on the passed ID.
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	app.renderSnippet(w, r, http.StatusOK, snippet, commentForm{})
+}
+
+// The renderSnippet helper gathers the forks, stars and comments of a
+// snippet and renders the snippet page, along with the comment form.
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, status int, snippet *models.Snippet, form commentForm) {
+	userID := app.authenticatedUserID(r)
+
+	forks, err := app.snippets.Forks(r.Context(), snippet.ID, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Top-level comments are shown oldest first unless ?order=newest is
+	// given.
+	order := r.URL.Query().Get("order")
+	if order != "newest" {
+		order = "oldest"
+	}
+	threads, err := app.comments.ListForSnippet(r.Context(), snippet.ID, order == "newest")
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.Snippets = forks
+	data.Comments = flattenComments(threads, snippet, userID, 0, nil)
+	data.CommentOrder = order
+	data.Form = form
+	if data.IsAuthenticated {
+		data.Starred, err = app.stars.IsStarred(r.Context(), userID, snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, status, "view.html", data)
+}
+
+// This handler copies a snippet into a new snippet owned by the current
+// user. Only snippets the user can view may be forked.
+func (app *application) snippetForkPost(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	id, err := app.snippets.Fork(r.Context(), snippet.ID, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Forked from snippet #%d.", snippet.ID))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// This handler stars a snippet for the current user and sends them back to
+// the snippet.
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.stars.Star(r.Context(), app.authenticatedUserID(r), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// This handler removes the current user's star from a snippet.
+func (app *application) snippetUnstarPost(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.stars.Unstar(r.Context(), app.authenticatedUserID(r), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// This handler adds a comment, or a reply to one, to a snippet the user can
+// view.
+func (app *application) commentCreatePost(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	var form commentForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var parent *models.Comment
+	if form.ParentID != 0 {
+		parent, err = app.comments.Get(r.Context(), form.ParentID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	checkComment(&form.Validator, &form, snippet, parent)
+	if !form.Valid() {
+		app.renderSnippet(w, r, http.StatusUnprocessableEntity, snippet, form)
+		return
+	}
+
+	id, err := app.comments.Insert(r.Context(), snippet.ID, app.authenticatedUserID(r),
+		form.ParentID, form.File, form.Line, form.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, id), http.StatusSeeOther)
+}
+
+// This handler deletes a comment and its replies. Only the author of the
+// comment and the owner of the snippet may delete it.
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	id := readIDParam(r)
+	if id == 0 {
+		app.notFound(w)
+		return
+	}
+
+	comment, err := app.comments.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	snippet, err := app.snippets.Get(r.Context(), comment.SnippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	if !snippet.CanView(userID) {
+		app.notFound(w)
+		return
+	}
+	if comment.UserID != userID && snippet.UserID != userID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.comments.Delete(r.Context(), comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Comment deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// This handler returns the raw content of a single file of a snippet as
+// plain text. Without a file name in the URL the first file is returned.
+// The ?lines=10-20 (or ?lines=10) query string parameter limits the
+// response to a range of lines.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	var file *models.SnippetFile
+	if name := params.ByName("name"); name != "" {
+		file = snippet.File(name)
+	} else if len(snippet.Files) > 0 {
+		file = snippet.Files[0]
+	}
+	if file == nil {
+		app.notFound(w)
+		return
+	}
+
+	content := file.Content
+	if r.URL.Query().Has("lines") {
+		lines := splitLines(content)
+		start, end, ok := parseLineRange(r.URL.Query().Get("lines"), len(lines))
+		if !ok {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		content = strings.Join(lines[start-1:end], "\n") + "\n"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err := w.Write([]byte(content))
+	if err != nil {
+		app.logger.Error(err.Error(), "request_id", requestIDFromContext(r.Context()))
+	}
+}
+
+// This handler returns all the files of a snippet as a zip archive.
+func (app *application) snippetZip(w http.ResponseWriter, r *http.Request) {
+	snippet := app.viewableSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	// Build the archive in memory first, so that errors can still be
+	// reported with a 500 response.
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, file := range snippet.Files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: snippet.Created,
+		})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		_, err = fw.Write([]byte(file.Content))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-	app.render(w, http.StatusOK, "view.html", data)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%d.zip"`, snippet.ID))
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		app.logger.Error(err.Error(), "request_id", requestIDFromContext(r.Context()))
+	}
 }
 
 // This handler handels POST requests to create a new snipppet in the database
@@ -87,26 +580,24 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	}
 
 	// Use Validator to check all fields.
-	form.CheckField(validator.NotBlank(form.Title), "title",
-		"This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title",
-		"This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content",
-		"This field cannot be blank")
-	form.CheckField(validator.PermitedValue(form.Expires, 1, 7, 365), "expires",
-		"This field must equal 1,7 or 365")
+	checkSnippet(&form.Validator, form.Title, form.Files, form.Expires, form.Visibility)
 	if !form.Valid() {
+		// Always show at least one file row, even if they were all removed.
+		if len(form.Files) == 0 {
+			form.Files = []snippetFileForm{{}}
+		}
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
 		return
 	}
 
 	// Pass the data to the SnippetModel.Insert() method, reciving the
 	// ID of the new record back
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(r.Context(), app.authenticatedUserID(r),
+		form.Title, toModelFiles(form.Files), form.Expires, form.Visibility)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	// Use the Put() method to add a string value and the correspondin key
@@ -120,9 +611,11 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = snippetCreateForm{
-		Expires: 365,
+		Files:      []snippetFileForm{{Language: "plaintext"}},
+		Expires:    365,
+		Visibility: models.VisibilityPublic,
 	}
-	app.render(w, http.StatusOK, "create.html", data)
+	app.render(w, r, http.StatusOK, "create.html", data)
 }
 
 // This handler handels DELETE requests to remove created snippets from database
@@ -133,9 +626,35 @@ func (app *application) snippetDelete(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w)
 		return
 	}
-	err = app.snippets.Delete(id)
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Only the owner of a snippet may delete it, like through the API.
+	userID := app.authenticatedUserID(r)
+	if !snippet.CanView(userID) {
+		app.notFound(w)
+		return
+	}
+	if snippet.UserID == 0 || snippet.UserID != userID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.snippets.Delete(r.Context(), snippet.ID)
 	if err != nil {
-		app.badRequest(w)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -143,13 +662,22 @@ func (app *application) snippetDelete(w http.ResponseWriter, r *http.Request) {
 
 // This handler handels GET requests to show user signup form
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	// Accounts are created on first login when a directory is configured.
+	if app.directory != nil {
+		app.notFound(w)
+		return
+	}
 	data := app.newTemplateData(r)
 	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.html", data)
+	app.render(w, r, http.StatusOK, "signup.html", data)
 }
 
 // This handler handels POST requests to save user info in the database
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	if app.directory != nil {
+		app.notFound(w)
+		return
+	}
 	var form userSignupForm
 	// Parse the form data into the userSignupForm struct
 	err := app.decodePostForm(r, &form)
@@ -171,27 +699,37 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		return
 	}
 	// Try to create a new user record in the database. If the email already
 	// exists then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err = app.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
+	// Send a link to verify the email address. This happens in the
+	// background so that the response isn't held up by the mail server.
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		err := app.sendVerification(ctx, form.Name, form.Email)
+		if err != nil {
+			app.logger.Error(err.Error(), "request_id", requestIDFromContext(ctx))
+		}
+	})
 	// Otherwise add a confirmation flash message to the session confirming that
 	// their signup worked
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash",
+		"Your signup was successful. We've sent you a link to verify your email address. Please log in.")
 	// And redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
@@ -200,7 +738,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.html", data)
+	app.render(w, r, http.StatusOK, "login.html", data)
 }
 
 // This handler handels POST requests to authinticate and login the user
@@ -223,49 +761,43 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
 		return
 	}
 	// Check whether the credentials are valid. If they're not, add a generic
-	// non-field error message and re-dispaly the login page.
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	// non-field error message and re-dispaly the login page. After too many
+	// failures the password isn't checked until the back-off has passed.
+	id, retryAt, err := app.passwordLogin(r, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			form.AddNonFieldError("Email or password is incorrect")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		} else if errors.Is(err, errTooManyAttempts) {
+			form.AddNonFieldError(loginLockedOutMsg)
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			setRetryAfter(w, retryAt)
+			app.render(w, r, http.StatusTooManyRequests, "login.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
-	// Use the RenewToken() method on the current session to change the session ID.
-	err = app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, err)
-		return
-	}
-	// Add the ID of the current user to the session, so that they are now
-	// "logged in"
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	app.completeLogin(w, r, id, form.Remember)
 }
 
 // This handler handels POST requests to logout the user
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	// Use the RenewToken() method on the current session to change the session
-	// ID again
-	err := app.sessionManager.RenewToken(r.Context())
+	// Log the session out, which also changes the session ID again
+	err := app.logOut(w, r)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
-	// Remove the authenticatedUserID from the session data so that the user
-	// is "logged out"
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
 	// Add a flash message to the session to confirm to the user they've been
 	// logged out
 	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
@@ -273,6 +805,732 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// This handler handels GET requests to show the user's API tokens and a form
+// to create a new one
+func (app *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = apiTokenCreateForm{}
+	app.renderTokens(w, r, http.StatusOK, data)
+}
+
+// This handler handels POST requests to create a new API token. The plaintext
+// token is put in the session and shown to the user exactly once.
+func (app *application) accountTokensPost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name",
+		"This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name",
+		"This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Scopes) > 0, "scopes",
+		"You must select at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(validator.PermitedValue(scope, models.ScopeRead, models.ScopeWrite, models.ScopeDelete),
+			"scopes", "This field must only contain read, write or delete")
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.renderTokens(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+
+	token, err := app.tokens.Insert(r.Context(), app.authenticatedUserID(r), form.Name, form.Scopes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "newAPIToken", token)
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+// This handler handels POST requests to revoke one of the user's API tokens
+func (app *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	id := readIDParam(r)
+	if id == 0 {
+		app.notFound(w)
+		return
+	}
+
+	err := app.tokens.Delete(r.Context(), id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "API token revoked.")
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+// The renderTokens helper adds the user's API tokens, and any newly created
+// token, to the template data and renders the tokens page.
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, status int, data *templateData) {
+	tokens, err := app.tokens.ListForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.APITokens = tokens
+	data.NewAPIToken = app.sessionManager.PopString(r.Context(), "newAPIToken")
+	app.render(w, r, status, "tokens.html", data)
+}
+
+// This handler shows a page of the snippets the current user has starred.
+func (app *application) userStarred(w http.ResponseWriter, r *http.Request) {
+	var v validator.Validator
+	page, pageSize := readPagination(r, &v)
+	if !v.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, total, err := app.stars.List(r.Context(), app.authenticatedUserID(r), pageSize, (page-1)*pageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = newPaginationMetadata(total, page, pageSize)
+
+	app.render(w, r, http.StatusOK, "starred.html", data)
+}
+
+// This handler shows the public profile of a user, with a page of their
+// public snippets. Private and unlisted snippets are never listed.
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	user := app.profileUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var v validator.Validator
+	page, pageSize := readPagination(r, &v)
+	if !v.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, total, err := app.snippets.Public(r.Context(), user.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Snippets = snippets
+	data.Pagination = newPaginationMetadata(total, page, pageSize)
+
+	app.render(w, r, http.StatusOK, "profile.html", data)
+}
+
+// This handler returns a user's avatar image.
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) {
+	user := app.profileUser(w, r)
+	if user == nil {
+		return
+	}
+
+	image, contentType, err := app.users.Avatar(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, err = w.Write(image)
+	if err != nil {
+		app.logger.Error(err.Error(), "request_id", requestIDFromContext(r.Context()))
+	}
+}
+
+// The profileUser helper returns the user named by the :id route parameter.
+// Disabled users don't have a profile. If the user can't be shown, a
+// response has already been sent and nil is returned.
+func (app *application) profileUser(w http.ResponseWriter, r *http.Request) *models.User {
+	id := readIDParam(r)
+	if id == 0 {
+		app.notFound(w)
+		return nil
+	}
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil
+	}
+	if user.Disabled {
+		app.notFound(w)
+		return nil
+	}
+	return user
+}
+
+// This handler shows the form to edit the current user's profile.
+func (app *application) accountProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = profileForm{Bio: user.Bio}
+	app.render(w, r, http.StatusOK, "profile_edit.html", data)
+}
+
+// This handler updates the current user's bio and avatar. The form is sent
+// as multipart/form-data so that it can include the avatar image.
+func (app *application) accountProfilePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	// Keep the whole form in memory, as it is no larger than
+	// maxProfileFormSize.
+	err := r.ParseMultipartForm(maxProfileFormSize)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		} else {
+			app.clientError(w, http.StatusBadRequest)
+		}
+		return
+	}
+
+	var form profileForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Bio, maxBioChars), "bio",
+		fmt.Sprintf("This field cannot be more than %d characters long", maxBioChars))
+
+	// An avatar is optional, and only read if one was uploaded.
+	var avatar []byte
+	var avatarType string
+	file, header, err := r.FormFile("avatar")
+	switch {
+	case errors.Is(err, http.ErrMissingFile):
+	case err != nil:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	default:
+		defer file.Close()
+		if header.Size > maxAvatarSize {
+			form.AddFieldError("avatar", fmt.Sprintf("The image cannot be larger than %d KB", maxAvatarSize>>10))
+			break
+		}
+		avatar, err = io.ReadAll(io.LimitReader(file, maxAvatarSize))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		// The type is detected from the content rather than trusting the
+		// browser's Content-Type.
+		avatarType = http.DetectContentType(avatar)
+		form.CheckField(validator.PermitedValue(avatarType, avatarTypes...), "avatar",
+			"The image must be a PNG, JPEG, GIF or WebP file")
+	}
+
+	if !form.Valid() {
+		user, err := app.users.Get(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "profile_edit.html", data)
+		return
+	}
+
+	err = app.users.UpdateBio(r.Context(), userID, strings.TrimSpace(form.Bio))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if avatar != nil || form.RemoveAvatar {
+		err = app.users.SetAvatar(r.Context(), userID, avatar, avatarType)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your profile has been updated.")
+	http.Redirect(w, r, fmt.Sprintf("/users/%d", userID), http.StatusSeeOther)
+}
+
+// This handler shows the current user's account details.
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	passkeys, err := app.passkeys.ListForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Passkeys = passkeys
+	app.render(w, r, http.StatusOK, "account.html", data)
+}
+
+// The checkCurrentPassword helper adds a field error to v if password isn't
+// the current user's password. Directory users' passwords are checked
+// against the directory. Only unexpected errors are returned.
+func (app *application) checkCurrentPassword(r *http.Request, v *validator.Validator, password string) error {
+	if !validator.NotBlank(password) {
+		v.AddFieldError("current_password", "This field cannot be blank")
+		return nil
+	}
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		return err
+	}
+	if app.isLocalUser(user.Email) {
+		err = app.users.CheckPassword(r.Context(), user.ID, password)
+	} else {
+		_, err = app.checkCredentials(r.Context(), user.Email, password)
+	}
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		v.AddFieldError("current_password", "Your current password is incorrect")
+		return nil
+	}
+	return err
+}
+
+// This handler shows the form to change the current user's name.
+func (app *application) accountName(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = accountNameForm{Name: user.Name}
+	app.render(w, r, http.StatusOK, "account_name.html", data)
+}
+
+func (app *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	var form accountNameForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name",
+		"This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name",
+		"This field cannot be more than 255 characters long")
+	err = app.checkCurrentPassword(r, &form.Validator, form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account_name.html", data)
+		return
+	}
+
+	err = app.users.UpdateName(r.Context(), app.authenticatedUserID(r), strings.TrimSpace(form.Name))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been changed.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// This handler shows the form to change the current user's email address.
+func (app *application) accountEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = accountEmailForm{Email: user.Email}
+	app.render(w, r, http.StatusOK, "account_email.html", data)
+}
+
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form accountEmailForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email",
+		"This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	err = app.checkCurrentPassword(r, &form.Validator, form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if form.Valid() {
+		err = app.users.UpdateEmail(r.Context(), app.authenticatedUserID(r), form.Email)
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account_email.html", data)
+		return
+	}
+
+	// The email address is used to log in, so change the session token.
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The new address has to be verified again.
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		err := app.sendVerification(ctx, user.Name, form.Email)
+		if err != nil {
+			app.logger.Error(err.Error(), "request_id", requestIDFromContext(ctx))
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash",
+		"Your email address has been changed. We've sent a link to verify it.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// This handler shows the form to change the current user's password.
+func (app *application) accountPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordForm{}
+	app.render(w, r, http.StatusOK, "account_password.html", data)
+}
+
+// This handler changes the current user's password. The session token is
+// renewed and the user's sessions on other devices are logged out.
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	var form accountPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "new_password",
+		"This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password",
+		"This field must be at least 8 characters long")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "new_password_confirmation",
+		"Passwords do not match")
+	err = app.checkCurrentPassword(r, &form.Validator, form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		// Don't send any of the passwords back to the browser.
+		form.CurrentPassword, form.NewPassword, form.NewPasswordConfirmation = "", "", ""
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account_password.html", data)
+		return
+	}
+
+	err = app.users.SetPassword(r.Context(), userID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.destroyOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed. You've been logged out on other devices.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// This handler shows the form to request a password reset link.
+func (app *application) userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = forgotPasswordForm{}
+	app.render(w, r, http.StatusOK, "forgot_password.html", data)
+}
+
+// This handler emails a password reset link to the given address. The
+// response is the same whether or not the address belongs to an account, and
+// the lookup and email happen in the background so that the response time
+// doesn't tell either.
+func (app *application) userForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email",
+		"This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot_password.html", data)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		err := app.sendPasswordReset(ctx, form.Email)
+		if err != nil {
+			app.logger.Error(err.Error(), "request_id", requestIDFromContext(ctx))
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash",
+		"If an account exists for that email address, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// The sendPasswordReset helper creates a reset token for the user with the
+// given email address and emails them the link. Unknown and disabled
+// accounts are ignored.
+func (app *application) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := app.users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil
+		}
+		return err
+	}
+	if user.Disabled {
+		return nil
+	}
+
+	token, err := app.resets.Insert(ctx, user.ID, resetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := app.baseURL + "/user/reset-password?token=" + url.QueryEscape(token)
+	return app.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Snippetbox password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your Snippetbox account. To choose a new\n"+
+			"password, open this link within the next hour:\n\n%s\n\n"+
+			"The link can only be used once. If you didn't ask for this, you can ignore\n"+
+			"this email and your password won't change.\n", user.Name, link),
+	})
+}
+
+// This handler shows the form to choose a new password, if the token from
+// the link is valid.
+func (app *application) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := app.resets.UserID(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidResetLink(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = resetPasswordForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset_password.html", data)
+}
+
+// This handler sets a new password using a reset token. The token is used
+// up, and every session of the user is logged out.
+func (app *application) userResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password",
+		"This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password",
+		"This field must be at least 8 characters long")
+	form.CheckField(form.Password == form.PasswordConfirmation, "password_confirmation",
+		"Passwords do not match")
+	if !form.Valid() {
+		form.Password, form.PasswordConfirmation = "", ""
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset_password.html", data)
+		return
+	}
+
+	userID, err := app.resets.Consume(r.Context(), form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.invalidResetLink(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.users.SetPassword(r.Context(), userID, form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.destroyOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// The invalidResetLink helper sends the user back to ask for a new link when
+// a reset token is unknown, expired or already used.
+func (app *application) invalidResetLink(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), "flash",
+		"That password reset link is invalid or has expired. Please ask for a new one.")
+	http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+}
+
+// The sendVerification helper emails a signed link which verifies the given
+// address. The link carries the address itself, so it stops working if the
+// user changes their address in the meantime.
+func (app *application) sendVerification(ctx context.Context, name, email string) error {
+	token := app.signer.Sign(verifyPurpose, email, time.Now().Add(verifyTokenTTL))
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+	return app.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm that this is your email address by opening this link within\n"+
+			"the next 24 hours:\n\n%s\n\n"+
+			"If you didn't sign up for Snippetbox, you can ignore this email.\n", name, link),
+	})
+}
+
+// This handler verifies an email address using the signed link from a
+// verification email.
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	email, err := app.signer.Verify(verifyPurpose, r.URL.Query().Get("token"), time.Now())
+	var user *models.User
+	if err == nil {
+		user, err = app.users.GetByEmail(r.Context(), email)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if err != nil || user.Disabled {
+		app.sessionManager.Put(r.Context(), "flash",
+			"That verification link is invalid or has expired. You can ask for a new one on your account page.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetVerified(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// This handler sends the current user a new verification email, at most
+// once every few minutes.
+func (app *application) accountVerifyPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	switch {
+	case user.Verified:
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified.")
+	case !app.verifyCooldown.allow(user.ID, time.Now()):
+		app.sessionManager.Put(r.Context(), "flash",
+			"We've sent you a verification email recently. Please wait a few minutes before asking for another.")
+	default:
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.sendVerification(ctx, user.Name, user.Email)
+			if err != nil {
+				app.logger.Error(err.Error(), "request_id", requestIDFromContext(ctx))
+			}
+		})
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("We've sent a new verification link to %s.", user.Email))
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	_, err := w.Write([]byte("OK"))
 	if err != nil {
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"mime/multipart"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/mailer"
 )
 
 func TestPing(t *testing.T) {
@@ -38,11 +44,40 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "Test content...",
 		},
+		{
+			name:     "Line numbers",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: `<span class="line" id="README.md-L3"><a class="line-number" href="#README.md-L3">3</a>line three`,
+		},
+		{
+			name:     "Rendered markdown",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: `<div class="markdown"><p>Second file`,
+		},
+		{
+			name:     "Fork count",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "Forks (1)",
+		},
+		{
+			name:     "Forked snippet",
+			urlPath:  "/snippet/view/4",
+			wantCode: http.StatusOK,
+			wantBody: `Forked from <a href="/snippet/view/1">#1</a>`,
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/2",
 			wantCode: http.StatusNotFound,
 		},
+		{
+			name:     "Private snippet",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusNotFound,
+		},
 		{
 			name:     "Negative ID",
 			urlPath:  "/snippet/view/-1",
@@ -78,6 +113,100 @@ func TestSnippetView(t *testing.T) {
 	}
 }
 
+func TestSnippetRaw(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Valid file",
+			urlPath:         "/snippet/raw/1/main.go",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "Test content...",
+		},
+		{
+			name:     "First file",
+			urlPath:  "/snippet/raw/1",
+			wantCode: http.StatusOK,
+			wantBody: "Test content...",
+		},
+		{
+			name:     "Line range",
+			urlPath:  "/snippet/raw/1/README.md?lines=2-3",
+			wantCode: http.StatusOK,
+			wantBody: "line two\nline three\n",
+		},
+		{
+			name:     "Single line",
+			urlPath:  "/snippet/raw/1/README.md?lines=2",
+			wantCode: http.StatusOK,
+			wantBody: "line two\n",
+		},
+		{
+			name:     "Range past the end",
+			urlPath:  "/snippet/raw/1/README.md?lines=3-99",
+			wantCode: http.StatusOK,
+			wantBody: "line three\n",
+		},
+		{
+			name:     "Range starting past the end",
+			urlPath:  "/snippet/raw/1/README.md?lines=4-5",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Malformed range",
+			urlPath:  "/snippet/raw/1?lines=10-2",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent file",
+			urlPath:  "/snippet/raw/1/missing.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Private snippet",
+			urlPath:  "/snippet/raw/3/secret.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:            "Zip archive",
+			urlPath:         "/snippet/zip/1",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/zip",
+			wantBody:        "main.go",
+		},
+		{
+			name:     "Private zip archive",
+			urlPath:  "/snippet/zip/3",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantContentType != "" {
+				assert.Equal(t, header.Get("Content-Type"), tt.wantContentType)
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
 func TestUserSignup(t *testing.T) {
 	app := newTestApplication(t)
 	ts := newTestServer(t, app.routes())
@@ -200,3 +329,885 @@ func TestUerSignup(t *testing.T) {
 		})
 	}
 }
+
+func TestSnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	code, _, body := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `name="files[0].name"`)
+
+	form := url.Values{}
+	form.Add("title", "Two files")
+	form.Add("files[0].name", "main.go")
+	form.Add("files[0].language", "go")
+	form.Add("files[0].content", "package main")
+	form.Add("files[1].name", "main.go")
+	form.Add("files[1].content", "duplicate")
+	form.Add("expires", "7")
+	form.Add("visibility", "public")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The file name is already used by another file")
+
+	form.Set("files[1].name", "MAIN.go")
+	code, _, body = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The file name is already used by another file")
+
+	form.Set("files[1].name", "README.md")
+	code, header, _ = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+}
+
+func TestSnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	del := func(csrfToken, id string) (int, http.Header) {
+		header := http.Header{}
+		header.Set("X-CSRF-Token", csrfToken)
+		code, header, _ := ts.do(t, http.MethodDelete, "/snippet/delete?id="+id, nil, header)
+		return code, header
+	}
+
+	_, _, body := ts.get(t, "/user/login")
+	code, header := del(extractCSRFToken(t, body), "1")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	// Snippet 1 is user 1's, and snippet 3 is user 1's private snippet.
+	csrfToken := ts.login(t, "other@example.com")
+	code, _ = del(csrfToken, "1")
+	assert.Equal(t, code, http.StatusForbidden)
+	code, _ = del(csrfToken, "3")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _ = del(csrfToken, "99")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	csrfToken = ts.login(t, "test@example.com")
+	code, header = del(csrfToken, "1")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Logging in as another user means the private snippet #3 is hidden.
+	csrfToken := ts.login(t, "other@example.com")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Valid ID",
+			urlPath:      "/snippet/fork/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/4",
+		},
+		{
+			name:     "Private snippet",
+			urlPath:  "/snippet/fork/3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/fork/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestSnippetStars(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/starred")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "&#9733; 1")
+	assert.StringContains(t, body, `value="Unstar"`)
+
+	for _, urlPath := range []string{"/snippet/star/1", "/snippet/unstar/1"} {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, header, _ = ts.postForm(t, urlPath, form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+	}
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/snippet/star/2", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, _, body = ts.get(t, "/user/starred")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<a href="/snippet/view/1">Test title</a>`)
+	assert.StringContains(t, body, "Page 1 of 1")
+
+	code, _, _ = ts.get(t, "/user/starred?page=0")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestSnippetComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "Comments (2)")
+	assert.StringContains(t, body, "Looks <strong>good</strong>")
+	assert.StringContains(t, body, `<a href="#main.go-L1">main.go line 1</a>`)
+	assert.StringContains(t, body, `class="comment depth-1" id="comment-2"`)
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	tests := []struct {
+		name         string
+		body         string
+		file         string
+		line         string
+		parentID     string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid comment",
+			body:         "Nice",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:         "Line comment",
+			body:         "Nice line",
+			file:         "main.go",
+			line:         "1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:         "Reply",
+			body:         "Agreed",
+			parentID:     "1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:     "Blank body",
+			body:     "   ",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Line out of range",
+			body:     "Nice line",
+			file:     "main.go",
+			line:     "2",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The line does not exist in this file",
+		},
+		{
+			name:     "Unknown file",
+			body:     "Nice line",
+			file:     "missing.go",
+			line:     "1",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The file does not exist in this snippet",
+		},
+		{
+			name:     "Unknown parent",
+			body:     "Agreed",
+			parentID: "99",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The comment you replied to does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("file", tt.file)
+			form.Add("line", tt.line)
+			form.Add("parent_id", tt.parentID)
+			form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, "/snippet/comment/1", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	// The owner of the snippet can delete other people's comments.
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, header, _ := ts.postForm(t, "/comment/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+
+	code, _, _ = ts.postForm(t, "/comment/delete/99", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestCommentDeleteForbidden(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Comment #2 was written by user 1 on user 1's snippet.
+	csrfToken := ts.login(t, "other@example.com")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/comment/delete/2", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantBody     []string
+		dontWantBody string
+	}{
+		{
+			name:         "Valid ID",
+			urlPath:      "/users/1",
+			wantCode:     http.StatusOK,
+			wantBody:     []string{"<h2>Test</h2>", "Writes <strong>Go</strong>", `src="/users/1/avatar"`, "Test title"},
+			dontWantBody: "Private title",
+		},
+		{
+			name:     "Another user",
+			urlPath:  "/users/2",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<h2>Other</h2>", "Forked title"},
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/users/99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/users/1?page=foo",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Avatar",
+			urlPath:  "/users/1/avatar",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "No avatar",
+			urlPath:  "/users/2/avatar",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			if tt.dontWantBody != "" && strings.Contains(body, tt.dontWantBody) {
+				t.Errorf("got body containing %q", tt.dontWantBody)
+			}
+		})
+	}
+}
+
+func TestAccountProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account/profile")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	code, _, body := ts.get(t, "/account/profile")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Writes **Go**")
+
+	tests := []struct {
+		name         string
+		bio          string
+		avatar       []byte
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid",
+			bio:          "New bio",
+			avatar:       []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"),
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/users/1",
+		},
+		{
+			name:         "No avatar",
+			bio:          "New bio",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/users/1",
+		},
+		{
+			name:     "Not an image",
+			avatar:   []byte("<html>not an image</html>"),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The image must be a PNG, JPEG, GIF or WebP file",
+		},
+		{
+			name:     "Avatar too large",
+			avatar:   bytes.Repeat([]byte("a"), maxAvatarSize+1),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The image cannot be larger than 256 KB",
+		},
+		{
+			name:     "Form too large",
+			avatar:   bytes.Repeat([]byte("a"), maxProfileFormSize),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "Bio too long",
+			bio:      strings.Repeat("a", maxBioChars+1),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 1000 characters long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			mw.WriteField("csrf_token", csrfToken)
+			mw.WriteField("bio", tt.bio)
+			if tt.avatar != nil {
+				fw, err := mw.CreateFormFile("avatar", "avatar.gif")
+				if err != nil {
+					t.Fatal(err)
+				}
+				fw.Write(tt.avatar)
+			}
+			mw.Close()
+
+			code, header, body := ts.do(t, http.MethodPost, "/account/profile", &buf,
+				http.Header{"Content-Type": {mw.FormDataContentType()}})
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	code, _, body := ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "test@example.com")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid name",
+			urlPath:  "/account/name",
+			form:     url.Values{"name": {"New Name"}, "current_password": {"pa$$word"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Blank name",
+			urlPath:  "/account/name",
+			form:     url.Values{"name": {" "}, "current_password": {"pa$$word"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Wrong password",
+			urlPath:  "/account/name",
+			form:     url.Values{"name": {"New Name"}, "current_password": {"wrong"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Your current password is incorrect",
+		},
+		{
+			name:     "Valid email",
+			urlPath:  "/account/email",
+			form:     url.Values{"email": {"new@example.com"}, "current_password": {"pa$$word"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid email",
+			urlPath:  "/account/email",
+			form:     url.Values{"email": {"new@"}, "current_password": {"pa$$word"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid email address",
+		},
+		{
+			name:     "Duplicate email",
+			urlPath:  "/account/email",
+			form:     url.Values{"email": {"dupe@example.com"}, "current_password": {"pa$$word"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Email address is already in use",
+		},
+		{
+			name:     "Email without password",
+			urlPath:  "/account/email",
+			form:     url.Values{"email": {"new@example.com"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:    "Short password",
+			urlPath: "/account/password",
+			form: url.Values{"current_password": {"pa$$word"}, "new_password": {"short"},
+				"new_password_confirmation": {"short"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be at least 8 characters long",
+		},
+		{
+			name:    "Mismatched passwords",
+			urlPath: "/account/password",
+			form: url.Values{"current_password": {"pa$$word"}, "new_password": {"new-pa$$word"},
+				"new_password_confirmation": {"other-pa$$word"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Passwords do not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, tt.urlPath, tt.form)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/account")
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountPasswordLogsOutOtherSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Log in twice with separate cookie jars, as if from two devices.
+	ts.login(t, "test@example.com")
+	otherDevice := ts.Client().Jar
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+	csrfToken := ts.login(t, "test@example.com")
+
+	form := url.Values{}
+	form.Add("current_password", "pa$$word")
+	form.Add("new_password", "new-pa$$word")
+	form.Add("new_password_confirmation", "new-pa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, header, _ := ts.postForm(t, "/account/password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account")
+
+	code, _, _ = ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusOK)
+
+	ts.Client().Jar = otherDevice
+	code, header, _ = ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestForgotPassword(t *testing.T) {
+	app := newTestApplication(t)
+	var mail bytes.Buffer
+	app.mailer = mailer.NewWriter(&mail, "no-reply@example.com")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot-password")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+		wantBody string
+		wantMail string
+	}{
+		{
+			name:     "Registered email",
+			email:    "test@example.com",
+			wantCode: http.StatusSeeOther,
+			wantMail: "https://snippetbox.test/user/reset-password?token=reset-token",
+		},
+		{
+			name:     "Unknown email",
+			email:    "nobody@example.com",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid email",
+			email:    "nobody@",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid email address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail.Reset()
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/forgot-password", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode == http.StatusSeeOther {
+				// The response doesn't tell whether the email is registered.
+				assert.Equal(t, header.Get("Location"), "/user/login")
+				_, _, body = ts.get(t, "/user/login")
+				assert.StringContains(t, body, "If an account exists for that email address")
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+			if tt.wantMail != "" {
+				assert.StringContains(t, mail.String(), "To: test@example.com")
+				assert.StringContains(t, mail.String(), tt.wantMail)
+			} else if mail.Len() > 0 {
+				t.Errorf("unexpected email sent: %q", mail.String())
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/reset-password?token=unknown")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/forgot-password")
+
+	code, _, body := ts.get(t, "/user/reset-password?token=reset-token")
+	assert.Equal(t, code, http.StatusOK)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		token        string
+		password     string
+		confirmation string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid",
+			token:        "reset-token",
+			password:     "new-pa$$word",
+			confirmation: "new-pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:         "Unknown token",
+			token:        "used-token",
+			password:     "new-pa$$word",
+			confirmation: "new-pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/forgot-password",
+		},
+		{
+			name:         "Short password",
+			token:        "reset-token",
+			password:     "short",
+			confirmation: "short",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This field must be at least 8 characters long",
+		},
+		{
+			name:         "Mismatched passwords",
+			token:        "reset-token",
+			password:     "new-pa$$word",
+			confirmation: "other-pa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Passwords do not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("token", tt.token)
+			form.Add("password", tt.password)
+			form.Add("password_confirmation", tt.confirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/reset-password", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUserVerify(t *testing.T) {
+	app := newTestApplication(t)
+	var mail bytes.Buffer
+	app.mailer = mailer.NewWriter(&mail, "no-reply@example.com")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Signing up sends a verification link.
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	app.wg.Wait()
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.StringContains(t, mail.String(), "To: bob@example.com")
+	assert.StringContains(t, mail.String(), "https://snippetbox.test/user/verify?token=")
+
+	tests := []struct {
+		name     string
+		token    string
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			token:    app.signer.Sign(verifyPurpose, "other@example.com", time.Now().Add(time.Hour)),
+			wantBody: "Your email address has been verified.",
+		},
+		{
+			name:     "Expired",
+			token:    app.signer.Sign(verifyPurpose, "other@example.com", time.Now().Add(-time.Second)),
+			wantBody: "That verification link is invalid or has expired.",
+		},
+		{
+			name:     "Unknown email",
+			token:    app.signer.Sign(verifyPurpose, "nobody@example.com", time.Now().Add(time.Hour)),
+			wantBody: "That verification link is invalid or has expired.",
+		},
+		{
+			name:     "Other purpose",
+			token:    app.signer.Sign("other", "other@example.com", time.Now().Add(time.Hour)),
+			wantBody: "That verification link is invalid or has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, "/user/verify?token="+url.QueryEscape(tt.token))
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/")
+
+			_, _, body := ts.get(t, "/")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestRequireVerified(t *testing.T) {
+	app := newTestApplication(t)
+	app.requireVerifiedEmail = true
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock user other@example.com hasn't verified their address.
+	ts.login(t, "other@example.com")
+	code, header, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account")
+
+	_, _, body := ts.get(t, "/account")
+	assert.StringContains(t, body, "Please verify your email address before creating snippets.")
+	assert.StringContains(t, body, "(not verified)")
+
+	ts.login(t, "test@example.com")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestRequireVerifiedAPI(t *testing.T) {
+	app := newTestApplication(t)
+	app.requireVerifiedEmail = true
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const body = `{"title": "Test title", "files": [{"name": "main.go", "content": "Test content..."}], "expires": 7}`
+
+	// The mock user other@example.com hasn't verified their address.
+	code, header, resp := ts.do(t, http.MethodPost, "/api/v1/snippets", strings.NewReader(body),
+		basicAuth("other@example.com", "pa$$word"))
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.Equal(t, header.Get("Content-Type"), "application/problem+json")
+	assert.StringContains(t, resp, "Please verify your email address before creating snippets.")
+
+	code, _, _ = ts.do(t, http.MethodPost, "/api/v1/snippets", strings.NewReader(body),
+		http.Header{"Authorization": {"Bearer sbx_full"}})
+	assert.Equal(t, code, http.StatusCreated)
+}
+
+func TestAccountVerify(t *testing.T) {
+	app := newTestApplication(t)
+	var mail bytes.Buffer
+	app.mailer = mailer.NewWriter(&mail, "no-reply@example.com")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "other@example.com")
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	// Only the first of two quick requests sends an email.
+	tests := []struct {
+		wantBody string
+		wantMail bool
+	}{
+		{wantBody: "a new verification link to other@example.com", wantMail: true},
+		{wantBody: "Please wait a few minutes before asking for another."},
+	}
+	for _, tt := range tests {
+		mail.Reset()
+		code, header, _ := ts.postForm(t, "/account/verify", form)
+		app.wg.Wait()
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account")
+		assert.Equal(t, mail.Len() > 0, tt.wantMail)
+
+		_, _, body := ts.get(t, "/account")
+		assert.StringContains(t, body, tt.wantBody)
+	}
+
+	csrfToken = ts.login(t, "test@example.com")
+	form.Set("csrf_token", csrfToken)
+	ts.postForm(t, "/account/verify", form)
+	_, _, body := ts.get(t, "/account")
+	assert.StringContains(t, body, "Your email address is already verified.")
+}
+
+func TestAccountTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	csrfToken := ts.login(t, "test@example.com")
+
+	code, _, body := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Everything")
+	assert.StringContains(t, body, "read, write, delete")
+
+	form := url.Values{}
+	form.Add("name", "CI")
+	form.Add("scopes", "read")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/tokens", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// The plaintext token is shown on the next page load only.
+	_, _, body = ts.get(t, "/account/tokens")
+	assert.StringContains(t, body, "sbx_newtoken")
+	_, _, body = ts.get(t, "/account/tokens")
+	if strings.Contains(body, "sbx_newtoken") {
+		t.Errorf("plaintext token shown more than once")
+	}
+
+	form = url.Values{}
+	form.Add("name", "CI")
+	form.Add("scopes", "admin")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/tokens", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/tokens/2/revoke", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.postForm(t, "/account/tokens/9/revoke", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
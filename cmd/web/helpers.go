@@ -2,12 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com.scottyfionnghall.snippetbox/internal/validator"
+
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
 )
@@ -48,17 +56,21 @@ func (app *application) newTemplateData(r *http.Request) *templateData {
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
+		SSOEnabled:      app.oidc != nil,
+		SignupEnabled:   app.directory == nil,
 	}
 }
 
-// The serverError helper writes an error message and stack trace to the errorLog,
-// then sends a generic 500 Internal Server Error response to the user.
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	err = app.errorLog.Output(2, trace)
-	if err != nil {
-		app.serverError(w, err)
-	}
+// The serverError helper writes an error message and stack trace to the logger,
+// tagged with the request ID, then sends a generic 500 Internal Server Error
+// response to the user.
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error(err.Error(),
+		"request_id", requestIDFromContext(r.Context()),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"trace", string(debug.Stack()),
+	)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
@@ -77,24 +89,29 @@ func (app *application) badRequest(w http.ResponseWriter) {
 	app.clientError(w, http.StatusBadRequest)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 	// Retrive the appropriate template set from the cache based on the page
 	// name. If no entry exists, the create a new error.
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 
 	}
 	// Initialize a new buffer
 	buf := new(bytes.Buffer)
 	// Write the template to the buffer, instead of straight to the
-	// http.ResponseWriter. If there's an error, call our serverError() helper
-	// and then return.
+	// http.ResponseWriter, timing the execution in its own span. If there's
+	// an error, call our serverError() helper and then return.
+	_, span := tracer.Start(r.Context(), "render "+page)
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		span.RecordError(err)
+	}
+	span.End()
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 	// Write out the provided HTTP status code
@@ -102,8 +119,160 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	// Write the contents of the buffer to the http.ResponseWriter
 	_, err = buf.WriteTo(w)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+	}
+}
+
+// The background helper runs fn in a new goroutine which is tracked by the
+// application's WaitGroup. A panic in fn is logged rather than crashing the
+// server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err), "trace", string(debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// Define a cooldown type which allows an action at most once per interval
+// for each key, such as sending an email to a user.
+type cooldown struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[int]time.Time
+}
+
+func newCooldown(interval time.Duration) *cooldown {
+	return &cooldown{interval: interval, last: make(map[int]time.Time)}
+}
+
+// allow reports whether the action may happen for key at the given time,
+// and if so records it. Keys whose interval has passed are forgotten.
+func (c *cooldown) allow(key int, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, t := range c.last {
+		if now.Sub(t) >= c.interval {
+			delete(c.last, k)
+		}
+	}
+	if _, ok := c.last[key]; ok {
+		return false
+	}
+	c.last[key] = now
+	return true
+}
+
+// The splitLines helper splits the content of a file into lines. A trailing
+// newline doesn't start another line.
+func splitLines(content string) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// The parseLineRange helper parses a line range such as "10-20" or "10" for
+// a file with total lines. The end of the range is limited to the last line;
+// ok is false if the range is malformed or starts after the last line.
+func parseLineRange(s string, total int) (start, end int, ok bool) {
+	first, last, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = start
+	if isRange {
+		end, err = strconv.Atoi(last)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	if start < 1 || end < start || start > total {
+		return 0, 0, false
+	}
+	return start, min(end, total), true
+}
+
+// The logIn helper logs the user with the given ID in, once they have been
+// fully authenticated. The session token is renewed first to prevent
+// session fixation. If remember is true, the browser is also given a
+// remember me token.
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, id int, remember bool) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	// Add the ID of the current user to the session, so that they are now
+	// "logged in"
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	err = app.trackSession(r, id)
+	if err != nil || !remember {
+		return err
+	}
+	return app.remember(w, r, id)
+}
+
+// The completeLogin helper finishes a login once the user with the given ID
+// has proven who they are, and redirects them to the create snippet page.
+// Users with two-factor authentication are sent to enter a code first.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id int, remember bool) {
+	enabled, err := app.twoFactorEnabled(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if enabled {
+		err = app.startTwoFactorLogin(r, id, remember)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	err = app.logIn(w, r, id, remember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// Return a copy of the request whose context marks the user with the given ID
+// as authenticated. It also records the ID for the access log.
+func setAuthenticatedUser(r *http.Request, id int) *http.Request {
+	ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+	ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		info.userID = id
+	}
+	return r.WithContext(ctx)
+}
+
+// Return the ID of the authenticated user making the current request, or 0
+// if the request is not authenticated.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+	return id
+}
+
+// The destroyOtherSessions helper logs out every session in which the given
+// user is logged in, except for the session of the current request. Their
+// remember me tokens are deleted too.
+func (app *application) destroyOtherSessions(r *http.Request, userID int) error {
+	err := app.rememberTokens.DeleteForUser(r.Context(), userID, app.currentSessionID(r))
+	if err != nil {
+		return err
 	}
+	return app.sessions.DeleteForUser(r.Context(), userID, app.currentSessionID(r))
 }
 
 // Return true if the current request if rom an authenticated user,
@@ -115,3 +284,128 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// Define an envelope type for the top-level JSON objects returned by the API.
+type envelope map[string]any
+
+// The problem type is an RFC 9457 problem details object. Errors holds the
+// field errors from a validator.Validator, if any.
+type problem struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title"`
+	Status int               `json:"status"`
+	Detail string            `json:"detail,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// The writeJSON helper encodes data as JSON and writes it to the response
+// with the given status code and any additional headers.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(js)
+	if err != nil {
+		app.logger.Error(err.Error(), "request_id", requestIDFromContext(r.Context()))
+	}
+}
+
+// The readJSON helper decodes a single JSON object from the request body into
+// dst, rejecting unknown fields and bodies larger than 1MB. The returned
+// errors are meant to be shown to the client.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// The problemResponse helper sends an application/problem+json response with
+// the given status and detail message.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	app.writeJSON(w, r, status, problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}, http.Header{"Content-Type": {"application/problem+json"}})
+}
+
+// The validationProblem helper sends a 422 problem response listing the field
+// errors collected by a validator.
+func (app *application) validationProblem(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	status := http.StatusUnprocessableEntity
+	app.writeJSON(w, r, status, problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: "The request contains invalid fields.",
+		Errors: v.FieldErrors,
+	}, http.Header{"Content-Type": {"application/problem+json"}})
+}
+
+// The apiServerError helper logs the error like serverError, but responds
+// with a problem object.
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error(err.Error(),
+		"request_id", requestIDFromContext(r.Context()),
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+		"trace", string(debug.Stack()),
+	)
+	app.problemResponse(w, r, http.StatusInternalServerError, "The server encountered a problem and could not process your request.")
+}
+
+// The invalidCredentials helper sends a 401 problem response asking the
+// client to authenticate.
+func (app *application) invalidCredentials(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="snippetbox", charset="UTF-8"`)
+	app.problemResponse(w, r, http.StatusUnauthorized, "You must be authenticated to access this resource.")
+}
+
+// The invalidToken helper sends a 401 problem response for a missing, unknown
+// or revoked bearer token.
+func (app *application) invalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="snippetbox"`)
+	app.problemResponse(w, r, http.StatusUnauthorized, "The API token is invalid or has been revoked.")
+}
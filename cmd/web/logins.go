@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// Password logins are throttled by email address and by client IP address.
+// Once the free failures in the last loginWindow are used up, every further
+// failure doubles the wait before the next attempt, up to loginMaxDelay,
+// which locks the account or address out until the wait is over. Addresses
+// are allowed more failures, as many users can share one.
+const (
+	loginWindow       = time.Hour
+	loginAccountFree  = 3
+	loginIPFree       = 20
+	loginBaseDelay    = time.Second
+	loginMaxDelay     = 15 * time.Minute
+	loginLockedOutMsg = "Too many failed login attempts. Please try again later."
+)
+
+// errTooManyAttempts is returned by passwordLogin while logins are
+// throttled.
+var errTooManyAttempts = errors.New("too many failed login attempts")
+
+// The loginDelay function returns how long to wait after the latest of the
+// given number of failures.
+func loginDelay(failures, free int) time.Duration {
+	n := failures - free
+	if n < 0 {
+		return 0
+	}
+	if n >= 20 {
+		return loginMaxDelay
+	}
+	return min(loginBaseDelay<<n, loginMaxDelay)
+}
+
+// The loginRetryAt function returns when the next attempt is allowed.
+func loginRetryAt(f *models.LoginFailures) time.Time {
+	retryAt := f.AccountLast.Add(loginDelay(f.Account, loginAccountFree))
+	ipRetryAt := f.IPLast.Add(loginDelay(f.IP, loginIPFree))
+	if ipRetryAt.After(retryAt) {
+		return ipRetryAt
+	}
+	return retryAt
+}
+
+// The clientIP function returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// The passwordLogin method checks an email address and password like
+// checkCredentials, and records the attempt. While there have been too many
+// failures for the address or the client, it returns errTooManyAttempts and
+// the time of the next allowed attempt, without checking the password. The
+// throttle doesn't depend on whether the email address has an account, so
+// it doesn't give away which addresses do.
+func (app *application) passwordLogin(r *http.Request, email, password string) (int, time.Time, error) {
+	attempt, retryAt, err := app.startLogin(r, email)
+	if err != nil {
+		return 0, retryAt, err
+	}
+
+	id, err := app.checkCredentials(r.Context(), email, password)
+	result := models.LoginSucceeded
+	switch {
+	case errors.Is(err, models.ErrInvalidCredentials):
+		result = models.LoginFailed
+	case err != nil:
+		result = models.LoginError
+	default:
+		// The login isn't complete until the two-factor code has been
+		// entered, so the earlier failures aren't cleared yet.
+		var enabled bool
+		enabled, err = app.twoFactorEnabled(r.Context(), id)
+		if err != nil {
+			result = models.LoginError
+		} else if enabled {
+			result = models.LoginPassword
+		}
+	}
+	finishErr := app.logins.Finish(r.Context(), attempt, result)
+	if finishErr != nil {
+		return 0, time.Time{}, finishErr
+	}
+	return id, time.Time{}, err
+}
+
+// The startLogin method records the start of a login attempt by the email
+// address, before its password or code is checked, and returns the
+// attempt's ID for logins.Finish. Attempts count as failures until they are
+// finished, so that a burst of attempts can't all get past the throttle.
+// While there have been too many failures for the email address or the
+// client, the attempt is recorded as blocked, and errTooManyAttempts is
+// returned with the time of the next allowed attempt.
+func (app *application) startLogin(r *http.Request, email string) (int, time.Time, error) {
+	attempt, failures, err := app.logins.Start(r.Context(), email, clientIP(r), loginWindow)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	retryAt := loginRetryAt(failures)
+	if time.Now().Before(retryAt) {
+		err = app.logins.Finish(r.Context(), attempt, models.LoginBlocked)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return 0, retryAt, errTooManyAttempts
+	}
+	return attempt, time.Time{}, nil
+}
+
+// The setRetryAfter helper sets the Retry-After header to the whole number
+// of seconds until retryAt.
+func setRetryAfter(w http.ResponseWriter, retryAt time.Time) {
+	seconds := int((time.Until(retryAt) + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
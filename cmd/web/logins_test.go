@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/mocks"
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 8, want: 32 * time.Second},
+		{failures: 13, want: loginMaxDelay},
+		{failures: 100, want: loginMaxDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.failures), func(t *testing.T) {
+			assert.Equal(t, loginDelay(tt.failures, loginAccountFree), tt.want)
+		})
+	}
+
+	// The later of the account's and the client's waits applies.
+	now := time.Now()
+	f := &models.LoginFailures{Account: 4, AccountLast: now, IP: 22, IPLast: now.Add(-time.Second)}
+	assert.Equal(t, loginRetryAt(f), now.Add(3*time.Second))
+	f.IP = 21
+	assert.Equal(t, loginRetryAt(f), now.Add(2*time.Second))
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Run("Account", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		for i := 0; i < loginAccountFree; i++ {
+			code, _, _ := ts.tryLogin(t, "test@example.com", "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+
+		// Even the right password is refused until the back-off is over,
+		// with the same message whatever the email address's case.
+		code, header, body := ts.tryLogin(t, "Test@Example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, header.Get("Retry-After"), "1")
+		assert.StringContains(t, body, "Too many failed login attempts.")
+
+		// Other accounts aren't affected.
+		code, _, _ = ts.tryLogin(t, "other@example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// Once the wait is over, a successful login clears the failures.
+		time.Sleep(loginBaseDelay)
+		code, _, _ = ts.tryLogin(t, "test@example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusSeeOther)
+		code, _, _ = ts.tryLogin(t, "test@example.com", "wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+		results := app.logins.(*mocks.LoginAttemptModel).Results()
+		assert.Equal(t, strings.Join(results, ","), "failure,failure,failure,blocked,success,success,failure")
+	})
+
+	t.Run("Unknown email", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Addresses without an account are throttled in the same way, so
+		// that the throttle doesn't show which addresses have one.
+		for i := 0; i < loginAccountFree; i++ {
+			code, _, _ := ts.tryLogin(t, "nobody@example.com", "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+		code, _, body := ts.tryLogin(t, "nobody@example.com", "wrong")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.StringContains(t, body, "Too many failed login attempts.")
+	})
+
+	t.Run("Client IP", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		for i := 0; i < loginIPFree; i++ {
+			code, _, _ := ts.tryLogin(t, fmt.Sprintf("guess%d@example.com", i), "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+		code, _, _ := ts.tryLogin(t, "test@example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		for i := 0; i < loginAccountFree; i++ {
+			code, _, _ := ts.do(t, http.MethodGet, "/api/v1/snippets", nil, basicAuth("test@example.com", "wrong"))
+			assert.Equal(t, code, http.StatusUnauthorized)
+		}
+		code, header, body := ts.do(t, http.MethodGet, "/api/v1/snippets", nil, basicAuth("test@example.com", "pa$$word"))
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, header.Get("Retry-After"), "1")
+		assert.StringContains(t, body, "Too many failed login attempts.")
+	})
+
+	t.Run("Parallel", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		// Attempts made at the same time count towards each other's
+		// throttle, so a burst only gets the free guesses.
+		const n = 20
+		codes := make(chan int, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/snippets", nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				req.SetBasicAuth("test@example.com", "wrong")
+				rs, err := ts.Client().Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rs.Body.Close()
+				codes <- rs.StatusCode
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		counts := map[int]int{}
+		for code := range codes {
+			counts[code]++
+		}
+		assert.Equal(t, counts[http.StatusUnauthorized], loginAccountFree)
+		assert.Equal(t, counts[http.StatusTooManyRequests], n-loginAccountFree)
+	})
+}
@@ -1,78 +1,300 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
-	"database/sql"
 	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com.scottyfionnghall.snippetbox/internal/config"
+	"github.com.scottyfionnghall.snippetbox/internal/directory"
+	"github.com.scottyfionnghall.snippetbox/internal/mailer"
 	"github.com.scottyfionnghall.snippetbox/internal/models"
+	"github.com.scottyfionnghall.snippetbox/internal/sealer"
+	"github.com.scottyfionnghall.snippetbox/internal/signer"
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 // Define an application struct to hold the application-wide dependencies.
 type application struct {
-	errorLog       *log.Logger
-	infoLog        *log.Logger
+	logger         *slog.Logger
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	tokens         models.TokenModelInterface
+	stars          models.StarModelInterface
+	comments       models.CommentModelInterface
+	resets         models.PasswordResetModelInterface
+	twoFactor      models.TwoFactorModelInterface
+	passkeys       models.PasskeyModelInterface
+	logins         models.LoginAttemptModelInterface
+	sessions       models.UserSessionModelInterface
+	rememberTokens models.RememberTokenModelInterface
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Mailer
+	baseURL        string
+	signer         *signer.Signer
+	// sealer encrypts two-factor secrets. It is nil if no encryption key
+	// is configured, and then two-factor authentication can't be enabled.
+	sealer *sealer.Sealer
+	// webAuthn registers passkeys and checks them at login.
+	webAuthn *webauthn.WebAuthn
+	// oidc is nil unless single sign-on is configured.
+	oidc *oidcLogin
+	// directory is nil unless passwords are checked against an LDAP
+	// directory. The users in localUsers still log in with local
+	// passwords.
+	directory  *directory.Directory
+	localUsers []string
+	// requireVerifiedEmail blocks creating snippets until the user's email
+	// address is verified, and verifyCooldown limits how often the
+	// verification email can be sent again.
+	requireVerifiedEmail bool
+	verifyCooldown       *cooldown
+	// wg tracks the goroutines started by the background helper.
+	wg sync.WaitGroup
 }
 
 func main() {
-	// Command-line argument "addr" to define address on wich the server
-	// will be listening.
-	addr := flag.String("addr", ":8080", "HTTP network address")
-	// Define command-line flag for the MySQL DSN string.
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true",
-		"MySQL data source name")
-	flag.Parse()
-	//Add info and error logger
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// Read the configuration from the command-line flags and environment.
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	// Create a structured logger writing to the standard out stream
+	logger, err := newLogger(os.Stdout, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Set up the global tracer provider and flush any pending spans on exit.
+	tp, err := newTracerProvider(context.Background(), cfg.TraceExporter, cfg.OTLPEndpoint, os.Stdout)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := tp.Shutdown(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
 	// Create a connection pool
-	db, err := openDB(*dsn)
+	db, err := config.OpenDB(cfg.DSN)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	// Initialize a new instance of our applicaiton struct, containing
 	// the dependencies
 	snippets, err := models.NewSnippetModel(db)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	defer func() {
 		err := snippets.CloseAll()
 		if err != nil {
-			errorLog.Fatal(err)
+			logger.Error(err.Error())
 		}
 	}()
-	users, err := models.NewUserModel(db)
+	hasher, err := cfg.PasswordHasher()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	users, err := models.NewUserModel(db, hasher)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	defer func() {
 		err := users.CloseAll()
 		if err != nil {
-			errorLog.Fatal(err)
+			logger.Error(err.Error())
+		}
+	}()
+	tokens, err := models.NewTokenModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := tokens.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	stars, err := models.NewStarModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := stars.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	comments, err := models.NewCommentModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := comments.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	resets, err := models.NewPasswordResetModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := resets.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	twoFactor, err := models.NewTwoFactorModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := twoFactor.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	passkeys, err := models.NewPasskeyModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := passkeys.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	logins, err := models.NewLoginAttemptModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := logins.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	userSessions, err := models.NewUserSessionModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := userSessions.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+	rememberTokens, err := models.NewRememberTokenModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		err := rememberTokens.CloseAll()
+		if err != nil {
+			logger.Error(err.Error())
 		}
 	}()
 	// Defer a db.Close() call
 	defer db.Close()
 	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	formDecoder := form.NewDecoder()
+	m, err := newMailer(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	// Without a configured key, links in emails stop working when the
+	// server restarts.
+	secretKey := []byte(cfg.SecretKey)
+	if len(secretKey) == 0 {
+		logger.Warn("no -secret-key set, using a random key")
+		secretKey = make([]byte, 32)
+		_, err = rand.Read(secretKey)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	var seal *sealer.Sealer
+	if cfg.EncryptionKey != "" {
+		seal, err = sealer.New(cfg.EncryptionKey)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	webAuthn, err := newWebAuthn(cfg.BaseURL)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	var sso *oidcLogin
+	if cfg.OIDCIssuer != "" {
+		sso, err = newOIDCLogin(context.Background(), cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret,
+			strings.TrimSuffix(cfg.BaseURL, "/")+"/user/login/sso/callback",
+			strings.Fields(cfg.OIDCScopes), strings.Split(cfg.OIDCAllowedDomains, ","))
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	var dir *directory.Directory
+	var localUsers []string
+	if cfg.LDAPURL != "" {
+		dir = &directory.Directory{
+			URL:          cfg.LDAPURL,
+			StartTLS:     cfg.LDAPStartTLS,
+			BindDN:       cfg.LDAPBindDN,
+			BindPassword: cfg.LDAPBindPassword,
+			BaseDN:       cfg.LDAPBaseDN,
+			Filter:       cfg.LDAPFilter,
+			NameAttr:     cfg.LDAPNameAttr,
+			EmailAttr:    cfg.LDAPEmailAttr,
+			GroupDN:      cfg.LDAPGroupDN,
+		}
+		for _, email := range strings.Split(cfg.LDAPLocalUsers, ",") {
+			email = strings.TrimSpace(email)
+			if email != "" {
+				localUsers = append(localUsers, email)
+			}
+		}
+	}
 	// Initialize new session manger and configure it to use MySQL database
 	// as the session store and set a lifetime of 12 hours
 	sessionManager := scs.New()
@@ -81,13 +303,32 @@ func main() {
 	sessionManager.Cookie.Secure = true
 
 	app := &application{
-		errorLog:       errorLog,
-		infoLog:        infoLog,
+		logger:         logger,
 		snippets:       snippets,
 		users:          users,
+		tokens:         tokens,
+		stars:          stars,
+		comments:       comments,
+		resets:         resets,
+		twoFactor:      twoFactor,
+		passkeys:       passkeys,
+		logins:         logins,
+		sessions:       userSessions,
+		rememberTokens: rememberTokens,
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         m,
+		baseURL:        strings.TrimSuffix(cfg.BaseURL, "/"),
+		signer:         signer.New(secretKey),
+		sealer:         seal,
+		webAuthn:       webAuthn,
+		oidc:           sso,
+		directory:      dir,
+		localUsers:     localUsers,
+
+		requireVerifiedEmail: cfg.RequireVerified,
+		verifyCooldown:       newCooldown(verifyResendInterval),
 	}
 	// Initialize a tls.Config struct to hold the non-default TLS settings.
 	// In this case we're changing only the curve preferences value.
@@ -96,8 +337,8 @@ func main() {
 	}
 	// Initialize a new http.Server struct
 	srv := &http.Server{
-		Addr:         *addr,
-		ErrorLog:     errorLog,
+		Addr:         cfg.Addr,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
 		IdleTimeout:  time.Minute,
@@ -105,20 +346,46 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 	// Start server
-	infoLog.Printf("Starting server on %s", *addr)
+	logger.Info("starting server", "addr", cfg.Addr)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	logger.Error(err.Error())
+	os.Exit(1)
 }
 
-// The openDB() function wraps sql.Open() and return a sql.DB connection pool
-// for a given DSN
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
+// The newLogger function returns a slog.Logger writing to w using either the
+// text or the JSON handler, depending on the requested format.
+func newLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
 	}
-	if err = db.Ping(); err != nil {
-		return nil, err
+}
+
+// The newMailer function returns the mailer selected by the configuration.
+// The file mailer appends to cfg.MailFile, which stays open while the server
+// runs.
+func newMailer(cfg *config.Config, logger *slog.Logger) (mailer.Mailer, error) {
+	switch cfg.Mailer {
+	case "log":
+		return &mailer.Log{Logger: logger}, nil
+	case "file":
+		f, err := os.OpenFile(cfg.MailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return mailer.NewWriter(f, cfg.MailSender), nil
+	case "smtp":
+		return &mailer.SMTP{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			Sender:   cfg.MailSender,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
 	}
-	return db, nil
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -55,3 +57,91 @@ func TestSecureHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestRequestID(t *testing.T) {
+	var gotID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = requestIDFromContext(r.Context())
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		generate bool
+	}{
+		{
+			name:   "Propagated",
+			header: "abc-123",
+		},
+		{
+			name:     "Missing",
+			header:   "",
+			generate: true,
+		},
+		{
+			name:     "Invalid",
+			header:   "bad id\nwith newline",
+			generate: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("X-Request-ID", tt.header)
+
+			requestID(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Header().Get("X-Request-ID"), gotID)
+			if tt.generate {
+				assert.Equal(t, len(gotID), 32)
+			} else {
+				assert.Equal(t, gotID, tt.header)
+			}
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{
+		logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, err := w.Write([]byte("OK"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	r, err := http.NewRequest(http.MethodGet, "/ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Request-ID", "abc-123")
+
+	requestID(app.logRequest(next)).ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry struct {
+		RequestID string `json:"request_id"`
+		URI       string `json:"uri"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, entry.RequestID, "abc-123")
+	assert.Equal(t, entry.URI, "/ping")
+	assert.Equal(t, entry.Status, http.StatusTeapot)
+	assert.Equal(t, entry.Bytes, 2)
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com.scottyfionnghall.snippetbox/internal/models"
 	"github.com/justinas/nosurf"
 )
 
@@ -20,18 +27,94 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				w.Header().Set("Connection", "close")
 				// Call the app.serverError helper method to return a 500
 				// Internal Server response.
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-// Function to log every request into infoLog
+// Incoming request IDs are only propagated if they look sane, so that clients
+// can't inject arbitrary data into our logs.
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// Function to assign an ID to every request. An existing X-Request-ID header
+// is propagated, otherwise a new random ID is generated. The ID is stored in
+// the request context and echoed back in the response headers.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				panic(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logResponseWriter wraps a http.ResponseWriter to record the status code
+// and the number of bytes written.
+type logResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *logResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *logResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (lw *logResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// Function to write an access log entry for every request once the handler
+// has returned, including the response status, size and duration.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		lw := &logResponseWriter{ResponseWriter: w}
+		// Inner middleware can't change our copy of the request, so give
+		// them a pointer to fill in instead.
+		info := &requestInfo{}
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		app.logger.Info("request",
+			"request_id", requestIDFromContext(r.Context()),
+			"remote_addr", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", lw.status,
+			"bytes", lw.bytes,
+			"duration", time.Since(start),
+			"user_id", info.userID,
+		)
 	})
 }
 
@@ -78,6 +161,95 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// The requireVerified middleware sends users whose email address isn't
+// verified to their account page, if the application requires verified
+// addresses. It must come after requireAuthentication.
+func (app *application) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.requireVerifiedEmail {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !user.Verified {
+			app.sessionManager.Put(r.Context(), "flash",
+				"Please verify your email address before creating snippets.")
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAPIVerified middleware is requireVerified for the JSON API,
+// which answers with a 403 problem instead. It must come after
+// requireAPIAuthentication.
+func (app *application) requireAPIVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.requireVerifiedEmail {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		if !user.Verified {
+			app.problemResponse(w, r, http.StatusForbidden,
+				"Please verify your email address before creating snippets.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireLocalAccount middleware sends directory users to their account
+// page, for pages which change details managed by the directory. It must
+// come after requireAuthentication.
+func (app *application) requireLocalAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.directory == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !app.isLocalUser(user.Email) {
+			app.sessionManager.Put(r.Context(), "flash",
+				"Your email address and password are managed by your organisation's directory.")
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The limitBody function returns middleware which refuses request bodies
+// larger than n bytes with 413 Request Entity Too Large. It must come before
+// noSurf, which parses the form to find the CSRF token, so that a large
+// body is refused before it has been read.
+func (app *application) limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				app.clientError(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			// Bodies without a Content-Length stop being read at the limit.
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // NoSurf middleware to prevent cross-site request forgery attacks.
 func noSurf(next http.Handler) http.Handler {
 	crsfHandler := nosurf.New(next)
@@ -100,19 +272,139 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		// If the session has been logged out from another device, throw it
+		// away and carry on unauthenticated.
+		ok, err := app.checkSession(r, id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !ok {
+			err = app.sessionManager.Destroy(r.Context())
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
 		// Otherwise, we check to see if a user with that ID exists in our
 		// database
-		exists, err := app.users.Exists(id)
+		exists, err := app.users.Exists(r.Context(), id)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 		// If a matching user is found, we know that the request is comming
 		// from an authenticated user who exists in out database.
 		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+			r = setAuthenticatedUser(r, id)
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Function to authenticate API requests. The API doesn't use sessions, so
+// clients send their email and password with every request using HTTP Basic
+// authentication. Requests without credentials carry on unauthenticated.
+// Requests already authenticated by authenticateToken are passed through.
+func (app *application) authenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Vary on the Authorization header, as responses depend on it.
+		w.Header().Add("Vary", "Authorization")
+
+		if app.isAuthenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		email, password, ok := r.BasicAuth()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, retryAt, err := app.passwordLogin(r, email, password)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.invalidCredentials(w, r)
+			} else if errors.Is(err, errTooManyAttempts) {
+				setRetryAfter(w, retryAt)
+				app.problemResponse(w, r, http.StatusTooManyRequests, loginLockedOutMsg)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+		// Basic auth can't ask for a code, so accounts with two-factor
+		// authentication have to use an API token instead.
+		enabled, err := app.twoFactorEnabled(r.Context(), id)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		if enabled {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="snippetbox"`)
+			app.problemResponse(w, r, http.StatusUnauthorized,
+				"This account uses two-factor authentication. Use an API token instead of a password.")
+			return
+		}
+
+		next.ServeHTTP(w, setAuthenticatedUser(r, id))
+	})
+}
+
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.invalidCredentials(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Function to authenticate requests carrying a personal API token in an
+// "Authorization: Bearer" header. It sets the same authenticated-user context
+// as the authenticate middleware, and also stores the token so that its
+// scopes can be checked by requireScope.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := app.tokens.GetByToken(r.Context(), plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.invalidToken(w, r)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		r = setAuthenticatedUser(r, token.UserID)
+		ctx := context.WithValue(r.Context(), apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The requireScope function returns middleware which rejects requests made
+// with an API token that hasn't been granted the scope. Requests
+// authenticated in other ways aren't limited by scopes.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+			if ok && !token.HasScope(scope) {
+				app.problemResponse(w, r, http.StatusForbidden,
+					fmt.Sprintf("This API token does not have the %q scope.", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Define an oidcLogin type to hold the settings for single sign-on with an
+// OpenID Connect provider, using the authorization code flow with PKCE.
+type oidcLogin struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// allowedDomains lists the email domains which may log in, in lower
+	// case. If it is empty, every domain may.
+	allowedDomains []string
+}
+
+// The newOIDCLogin function fetches the provider's configuration from its
+// discovery document. The provider redirects users back to redirectURL.
+func newOIDCLogin(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, scopes, allowedDomains []string) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	login := &oidcLogin{
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			login.allowedDomains = append(login.allowedDomains, domain)
+		}
+	}
+	return login, nil
+}
+
+// The allowed method reports whether a user with the given email address
+// may log in.
+func (l *oidcLogin) allowed(email string) bool {
+	if len(l.allowedDomains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	for _, allowed := range l.allowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Define an oidcClaims struct for the claims read from an ID token.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// The randomState function returns a random string for the state and nonce
+// parameters.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// This handler starts a single sign-on login by sending the user to the
+// provider. The state, nonce and PKCE verifier are kept in the session
+// until the provider sends the user back.
+func (app *application) userLoginSSO(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state, err := randomState()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	nonce, err := randomState()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	authURL := app.oidc.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// The ssoFailed helper sends the user back to the login page with a flash
+// message.
+func (app *application) ssoFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.sessionManager.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// This handler receives the user back from the provider. It exchanges the
+// code for an ID token and logs in the user with the token's verified email
+// address, creating an account for them if there isn't one yet.
+func (app *application) userLoginSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// The state, nonce and verifier are used once, whatever the outcome.
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		app.ssoFailed(w, r, "Your login has expired. Please try again.")
+		return
+	}
+	if query.Get("error") != "" {
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+
+	token, err := app.oidc.oauth2.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.logger.Warn("oidc code exchange failed", "error", err, "request_id", requestIDFromContext(r.Context()))
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+	idToken, err := app.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		app.logger.Warn("oidc id token rejected", "error", err, "request_id", requestIDFromContext(r.Context()))
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+	if idToken.Nonce != nonce {
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Accounts are linked by email address, so it has to be verified by
+	// the provider.
+	if claims.Email == "" || !claims.EmailVerified {
+		app.ssoFailed(w, r, "Your identity provider has not verified your email address.")
+		return
+	}
+	if !app.oidc.allowed(claims.Email) {
+		app.ssoFailed(w, r, "Accounts from your email domain can not log in here.")
+		return
+	}
+
+	id, err := app.externalUser(r.Context(), claims.Name, claims.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.ssoFailed(w, r, "Your account has been disabled.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.completeLogin(w, r, id, false)
+}
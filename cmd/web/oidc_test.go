@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+// The fakeIssuer type is an in-process OpenID Connect provider. It logs in
+// whoever is set as its identity without asking, and only accepts the
+// authorization code flow with S256 PKCE.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// identity holds the claims for the next login. If it is nil, the
+	// user refuses to log in.
+	identity map[string]any
+	grants   map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	claims      map[string]any
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+const (
+	fakeClientID     = "snippetbox"
+	fakeClientSecret = "s3cret"
+)
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, grants: map[string]fakeGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/jwks", f.jwks)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeIssuer) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	f.writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                f.URL,
+		"authorization_endpoint":                f.URL + "/authorize",
+		"token_endpoint":                        f.URL + "/token",
+		"jwks_uri":                              f.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (f *fakeIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	f.writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	params := url.Values{"state": {q.Get("state")}}
+	if f.identity == nil {
+		params.Set("error", "access_denied")
+	} else {
+		code, err := randomState()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.grants[code] = fakeGrant{
+			claims:      f.identity,
+			nonce:       q.Get("nonce"),
+			challenge:   q.Get("code_challenge"),
+			redirectURI: q.Get("redirect_uri"),
+		}
+		params.Set("code", code)
+	}
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != fakeClientID || clientSecret != fakeClientSecret {
+		f.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes work once, and only with the verifier for their challenge.
+	f.mu.Lock()
+	grant, ok := f.grants[r.PostFormValue("code")]
+	delete(f.grants, r.PostFormValue("code"))
+	f.mu.Unlock()
+	verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifierHash[:]) != grant.challenge {
+		f.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   f.URL,
+		"sub":   grant.claims["email"],
+		"aud":   fakeClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	idToken, err := f.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// The sign method returns claims as a JWT signed with RS256.
+func (f *fakeIssuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func TestSingleSignOnDisabled(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.Equal(t, strings.Contains(body, "single sign-on"), false)
+
+	code, _, _ := ts.get(t, "/user/login/sso")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestSingleSignOn(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	tests := []struct {
+		name         string
+		identity     map[string]any
+		twoFactor    bool
+		badState     bool
+		wantLocation string
+		wantFlash    string
+	}{
+		{
+			name:         "Existing user",
+			identity:     map[string]any{"email": "test@example.com", "email_verified": true},
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "New user",
+			identity:     map[string]any{"email": "new@example.com", "email_verified": true, "name": "New"},
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Two-factor authentication",
+			identity:     map[string]any{"email": "test@example.com", "email_verified": true},
+			twoFactor:    true,
+			wantLocation: "/user/login/2fa",
+		},
+		{
+			name:         "Unverified email",
+			identity:     map[string]any{"email": "new@example.com", "email_verified": false},
+			wantLocation: "/user/login",
+			wantFlash:    "has not verified your email address",
+		},
+		{
+			name:         "Domain not allowed",
+			identity:     map[string]any{"email": "someone@example.org", "email_verified": true},
+			wantLocation: "/user/login",
+			wantFlash:    "can not log in here",
+		},
+		{
+			name:         "Login refused",
+			wantLocation: "/user/login",
+			wantFlash:    "Single sign-on was cancelled or failed.",
+		},
+		{
+			name:         "Wrong state",
+			identity:     map[string]any{"email": "test@example.com", "email_verified": true},
+			badState:     true,
+			wantLocation: "/user/login",
+			wantFlash:    "Your login has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			var err error
+			app.oidc, err = newOIDCLogin(context.Background(), issuer.URL, fakeClientID, fakeClientSecret,
+				app.baseURL+"/user/login/sso/callback", []string{"openid", "email", "profile"}, []string{"Example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.twoFactor {
+				app.twoFactor.SetSecret(context.Background(), 1, nil)
+				app.twoFactor.Enable(context.Background(), 1, 0)
+			}
+			issuer.mu.Lock()
+			issuer.identity = tt.identity
+			issuer.mu.Unlock()
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, "Log in with single sign-on")
+
+			// Follow the redirects to the provider and back by hand, since
+			// the callback URL points at the public base URL.
+			code, header, _ := ts.get(t, "/user/login/sso")
+			assert.Equal(t, code, http.StatusSeeOther)
+			authURL := header.Get("Location")
+			assert.StringContains(t, authURL, issuer.URL+"/authorize?")
+
+			rs, err := ts.Client().Get(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+			assert.Equal(t, rs.StatusCode, http.StatusFound)
+			callback, err := url.Parse(rs.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, callback.Path, "/user/login/sso/callback")
+			if tt.badState {
+				q := callback.Query()
+				q.Set("state", "forged")
+				callback.RawQuery = q.Encode()
+			}
+
+			code, header, _ = ts.get(t, callback.RequestURI())
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			code, _, body = ts.get(t, tt.wantLocation)
+			assert.Equal(t, code, http.StatusOK)
+			if tt.wantFlash != "" {
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+
+			// The state and verifier can't be used again.
+			code, header, _ = ts.get(t, callback.RequestURI())
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+	"github.com.scottyfionnghall.snippetbox/internal/validator"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// A registration or login ceremony has to be finished within a few minutes
+// of starting it.
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// The newWebAuthn function returns the WebAuthn relying party for a server
+// reachable at baseURL. Passkeys are bound to its host name, so they stop
+// working if it changes.
+//
+// Passkeys are discoverable credentials which always verify the user, e.g.
+// with a PIN or fingerprint, so they replace both the password and a second
+// factor.
+func newWebAuthn(baseURL string) (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTTL}
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: "Snippetbox",
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// Define a passkeyUser type which adapts a user and their passkeys to the
+// webauthn.User interface. The user handle stored on the authenticator is
+// the user's ID.
+type passkeyUser struct {
+	user        *models.User
+	passkeys    []*models.Passkey
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.Itoa(u.user.ID))
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+// The passkey method returns the stored passkey for a credential, or nil.
+func (u *passkeyUser) passkey(credentialID []byte) *models.Passkey {
+	for _, p := range u.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return p
+		}
+	}
+	return nil
+}
+
+// The passkeyUser helper loads the user with the given ID and decodes their
+// passkeys.
+func (app *application) passkeyUser(ctx context.Context, id int) (*passkeyUser, error) {
+	user, err := app.users.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := app.passkeys.ListForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	u := &passkeyUser{user: user, passkeys: passkeys}
+	for _, p := range passkeys {
+		var cred webauthn.Credential
+		err = json.Unmarshal(p.Credential, &cred)
+		if err != nil {
+			return nil, err
+		}
+		u.credentials = append(u.credentials, cred)
+	}
+	return u, nil
+}
+
+// The startPasskeyCeremony helper stores the state of a registration or
+// login in the session under key, until the browser sends its response.
+func (app *application) startPasskeyCeremony(r *http.Request, key string, session *webauthn.SessionData) error {
+	js, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), key, js)
+	return nil
+}
+
+// The endPasskeyCeremony helper removes the state stored under key from
+// the session and returns it, so that every challenge is used once. It
+// returns nil if there is no such ceremony.
+func (app *application) endPasskeyCeremony(r *http.Request, key string) *webauthn.SessionData {
+	js := app.sessionManager.PopBytes(r.Context(), key)
+	if js == nil {
+		return nil
+	}
+	var session webauthn.SessionData
+	err := json.Unmarshal(js, &session)
+	if err != nil {
+		return nil
+	}
+	return &session
+}
+
+// Define a passkeyBeginInput struct to hold the body of a request to start
+// registering a passkey.
+type passkeyBeginInput struct {
+	CurrentPassword     string `json:"current_password"`
+	validator.Validator `json:"-"`
+}
+
+// This handler starts registering a new passkey for the current user. It
+// returns the options for navigator.credentials.create() as JSON. A passkey
+// logs in without the password or a two-factor code, so the current
+// password is asked for first, as a stolen session shouldn't be enough to
+// add one.
+func (app *application) accountPasskeyBeginPost(w http.ResponseWriter, r *http.Request) {
+	var input passkeyBeginInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.problemResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = app.checkCurrentPassword(r, &input.Validator, input.CurrentPassword)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	if !input.Valid() {
+		app.validationProblem(w, r, input.Validator)
+		return
+	}
+
+	u, err := app.passkeyUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// Authenticators which already hold one of the user's passkeys refuse
+	// to create another one.
+	exclude := make([]protocol.CredentialDescriptor, len(u.credentials))
+	for i, cred := range u.credentials {
+		exclude[i] = cred.Descriptor()
+	}
+	options, session, err := app.webAuthn.BeginRegistration(u, webauthn.WithExclusions(exclude))
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	err = app.startPasskeyCeremony(r, "passkeyRegistration", session)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, options, nil)
+}
+
+// This handler checks the new credential sent by the browser and stores it.
+// The passkey's name is sent in the query string, because the body is the
+// credential.
+func (app *application) accountPasskeyFinishPost(w http.ResponseWriter, r *http.Request) {
+	session := app.endPasskeyCeremony(r, "passkeyRegistration")
+	if session == nil {
+		app.problemResponse(w, r, http.StatusBadRequest, "No passkey registration is in progress.")
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	var v validator.Validator
+	v.CheckField(validator.MaxChars(name, 100), "name", "This field cannot be more than 100 characters long")
+	if !v.Valid() {
+		app.validationProblem(w, r, v)
+		return
+	}
+
+	u, err := app.passkeyUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	cred, err := app.webAuthn.FinishRegistration(u, *session, r)
+	if err != nil {
+		app.problemResponse(w, r, http.StatusBadRequest, "The passkey could not be registered.")
+		return
+	}
+	js, err := json.Marshal(cred)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	err = app.passkeys.Insert(r.Context(), u.user.ID, name, cred.ID, js)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateCredential) {
+			app.problemResponse(w, r, http.StatusConflict, "This passkey is already registered.")
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Passkey added.")
+	app.writeJSON(w, r, http.StatusCreated, map[string]string{"redirect": "/account"}, nil)
+}
+
+// This handler removes one of the current user's passkeys.
+func (app *application) accountPasskeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	id := readIDParam(r)
+	if id == 0 {
+		app.notFound(w)
+		return
+	}
+
+	err := app.passkeys.Delete(r.Context(), id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Passkey removed.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// This handler starts logging in with a passkey. The browser lets the user
+// pick one of their passkeys for this site, so no email address is needed.
+// It returns the options for navigator.credentials.get() as JSON.
+func (app *application) userPasskeyBeginPost(w http.ResponseWriter, r *http.Request) {
+	options, session, err := app.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	err = app.startPasskeyCeremony(r, "passkeyLogin", session)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, options, nil)
+}
+
+// This handler checks the signature sent by the browser and logs the owner
+// of the passkey in. Disabled users and passkeys which look cloned are
+// rejected.
+func (app *application) userPasskeyFinishPost(w http.ResponseWriter, r *http.Request) {
+	session := app.endPasskeyCeremony(r, "passkeyLogin")
+	if session == nil {
+		app.problemResponse(w, r, http.StatusBadRequest, "No passkey login is in progress.")
+		return
+	}
+
+	var u *passkeyUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, models.ErrNoRecord
+		}
+		u, err = app.passkeyUser(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		if u.user.Disabled {
+			return nil, models.ErrNoRecord
+		}
+		return u, nil
+	}
+	cred, err := app.webAuthn.FinishDiscoverableLogin(findUser, *session, r)
+	if err != nil || cred.Authenticator.CloneWarning {
+		app.problemResponse(w, r, http.StatusUnauthorized, "The passkey could not be verified.")
+		return
+	}
+
+	// Store the new signature counter, which is how cloned authenticators
+	// are detected.
+	p := u.passkey(cred.ID)
+	if p == nil {
+		app.apiServerError(w, r, errors.New("verified passkey is not stored"))
+		return
+	}
+	js, err := json.Marshal(cred)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	err = app.passkeys.Update(r.Context(), p.ID, js)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.endTwoFactorLogin(r)
+	err = app.logIn(w, r, u.user.ID, false)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	app.writeJSON(w, r, http.StatusOK, map[string]string{"redirect": "/snippet/create"}, nil)
+}
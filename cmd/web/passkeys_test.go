@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// The softAuthenticator type stands in for a platform authenticator or
+// security key. It holds a single P-256 key and answers registration and
+// login requests the way a browser would pass them on.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: id, origin: "https://snippetbox.test"}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, challenge []byte) []byte {
+	js, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": b64(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// The create method answers the options returned when registration begins,
+// with "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, optionsJSON string) string {
+	var options struct {
+		PublicKey struct {
+			Challenge protocol.URLEncodedBase64 `json:"challenge"`
+			RP        struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			User struct {
+				ID protocol.URLEncodedBase64 `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal([]byte(optionsJSON), &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = options.PublicKey.User.ID
+
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // EC2 key type
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := a.authData(options.PublicKey.RP.ID, flagUserPresent|flagUserVerified|flagAttested)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"attestationObject": b64(attestation),
+			"clientDataJSON":    b64(a.clientData(t, "webauthn.create", options.PublicKey.Challenge)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+// The get method answers the options returned when a login begins, signing
+// with the given authenticator data flags.
+func (a *softAuthenticator) get(t *testing.T, optionsJSON string, flags byte) string {
+	var options struct {
+		PublicKey struct {
+			Challenge protocol.URLEncodedBase64 `json:"challenge"`
+			RPID      string                    `json:"rpId"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal([]byte(optionsJSON), &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authData(options.PublicKey.RPID, flags)
+	clientData := a.clientData(t, "webauthn.get", options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"authenticatorData": b64(authData),
+			"clientDataJSON":    b64(clientData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+func TestPasskeys(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	postJSON := func(urlPath, csrfToken, body string) (int, string) {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		header.Set("X-CSRF-Token", csrfToken)
+		code, _, rsBody := ts.do(t, http.MethodPost, urlPath, bytes.NewBufferString(body), header)
+		return code, rsBody
+	}
+
+	// Register a passkey for the test user.
+	csrfToken := ts.login(t, "test@example.com")
+	password := `{"current_password": "pa$$word"}`
+	_, _, body := ts.get(t, "/account")
+	assert.StringContains(t, body, "Add a passkey")
+
+	code, _ := postJSON("/account/passkeys/begin", "wrong", password)
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	// The current password has to be entered first.
+	code, body = postJSON("/account/passkeys/begin", csrfToken, `{"current_password": "wrong"}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Your current password is incorrect")
+	code, _ = postJSON("/account/passkeys/begin", csrfToken, "")
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	auth := newSoftAuthenticator(t)
+	code, body = postJSON("/account/passkeys/begin", csrfToken, password)
+	assert.Equal(t, code, http.StatusOK)
+	credential := auth.create(t, body)
+
+	code, body = postJSON("/account/passkeys/finish?name=Laptop", csrfToken, credential)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.StringContains(t, body, `"redirect": "/account"`)
+
+	// Every challenge can only be answered once.
+	code, _ = postJSON("/account/passkeys/finish?name=Laptop", csrfToken, credential)
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	_, _, body = ts.get(t, "/account")
+	assert.StringContains(t, body, "Passkey added.")
+	assert.StringContains(t, body, "<td>Laptop</td>")
+
+	// The same authenticator can't be registered twice.
+	code, body = postJSON("/account/passkeys/begin", csrfToken, password)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, b64(auth.credentialID))
+	code, _ = postJSON("/account/passkeys/finish", csrfToken, auth.create(t, body))
+	assert.Equal(t, code, http.StatusConflict)
+
+	// Log in with the passkey from a new browser.
+	logInWithPasskey := func(auth *softAuthenticator, flags byte) int {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Client().Jar = jar
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, body, "Log in with a passkey")
+		csrfToken := extractCSRFToken(t, body)
+		code, body := postJSON("/user/login/passkey/begin", csrfToken, "")
+		assert.Equal(t, code, http.StatusOK)
+
+		auth.signCount++
+		code, _ = postJSON("/user/login/passkey/finish", csrfToken, auth.get(t, body, flags))
+		return code
+	}
+
+	code = logInWithPasskey(auth, flagUserPresent|flagUserVerified)
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	tests := []struct {
+		name  string
+		auth  func() *softAuthenticator
+		flags byte
+	}{
+		{
+			name:  "User not verified",
+			auth:  func() *softAuthenticator { return auth },
+			flags: flagUserPresent,
+		},
+		{
+			name: "Unknown passkey",
+			auth: func() *softAuthenticator {
+				other := newSoftAuthenticator(t)
+				other.userHandle = auth.userHandle
+				return other
+			},
+			flags: flagUserPresent | flagUserVerified,
+		},
+		{
+			name: "Wrong key",
+			auth: func() *softAuthenticator {
+				other := newSoftAuthenticator(t)
+				other.credentialID = auth.credentialID
+				other.userHandle = auth.userHandle
+				other.signCount = auth.signCount
+				return other
+			},
+			flags: flagUserPresent | flagUserVerified,
+		},
+		{
+			name: "Cloned authenticator",
+			auth: func() *softAuthenticator {
+				clone := *auth
+				clone.signCount = 0
+				return &clone
+			},
+			flags: flagUserPresent | flagUserVerified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := logInWithPasskey(tt.auth(), tt.flags)
+			assert.Equal(t, code, http.StatusUnauthorized)
+
+			code, _, _ = ts.get(t, "/snippet/create")
+			assert.Equal(t, code, http.StatusSeeOther)
+		})
+	}
+
+	// Once removed, the passkey no longer works.
+	csrfToken = ts.login(t, "test@example.com")
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/passkeys/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.postForm(t, "/account/passkeys/delete/1", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code = logInWithPasskey(auth, flagUserPresent|flagUserVerified)
+	assert.Equal(t, code, http.StatusUnauthorized)
+}
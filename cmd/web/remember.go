@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// How long "remember me" keeps a browser logged in without using it, the
+// name of the cookie holding its token, and how long after a token is used
+// it may be presented again without being taken for a replay.
+const (
+	rememberTTL        = 30 * 24 * time.Hour
+	rememberCookieName = "remember_me"
+	rememberReuseGrace = 5 * time.Second
+)
+
+// The remember helper issues a new remember me token for the current
+// session of the user with the given ID, and sends it in a cookie.
+func (app *application) remember(w http.ResponseWriter, r *http.Request, userID int) error {
+	token, err := app.rememberTokens.Insert(r.Context(), userID, app.currentSessionID(r), rememberTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(rememberTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// The forget function removes the remember me cookie from the browser.
+func forget(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// The restoreSession middleware logs a browser back in with its remember me
+// token once its session has expired. Each token can be used once, and is
+// replaced by a new one. A token which is used again has probably been
+// stolen, so all of the user's tokens and sessions are logged out. Only
+// once the grace period has passed though: a browser may send several
+// requests at once with the same token, and the later ones are served
+// without logging in, keeping the cookies which the first one sets.
+func (app *application) restoreSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.sessionManager.GetInt(r.Context(), "authenticatedUserID") != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(rememberCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := app.rememberTokens.Consume(r.Context(), cookie.Value, rememberReuseGrace)
+		switch {
+		case errors.Is(err, models.ErrTokenReplayed):
+			app.logger.Warn("remember me token replayed, logging out all sessions",
+				"user_id", id, "ip", clientIP(r), "request_id", requestIDFromContext(r.Context()))
+			err = app.rememberTokens.DeleteForUser(r.Context(), id, 0)
+			if err == nil {
+				err = app.sessions.DeleteForUser(r.Context(), id, 0)
+			}
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			forget(w)
+		case errors.Is(err, models.ErrTokenRecentlyUsed):
+		case errors.Is(err, models.ErrNoRecord):
+			forget(w)
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		default:
+			// Disabled and deleted users aren't logged back in.
+			exists, err := app.users.Exists(r.Context(), id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if !exists {
+				forget(w)
+				break
+			}
+			err = app.logIn(w, r, id, true)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
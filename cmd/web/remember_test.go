@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/mocks"
+)
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	tokens := app.rememberTokens.(*mocks.RememberTokenModel)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The browser function switches to a new browser, which only has the
+	// given remember me token, as if its session had expired.
+	browser := func(token string) *cookiejar.Jar {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: token}})
+		}
+		ts.Client().Jar = jar
+		return jar
+	}
+	rememberToken := func() string {
+		for _, c := range ts.Client().Jar.Cookies(u) {
+			if c.Name == rememberCookieName {
+				return c.Value
+			}
+		}
+		return ""
+	}
+	loggedIn := func() bool {
+		code, _, _ := ts.get(t, "/snippet/create")
+		return code == http.StatusOK
+	}
+	login := func(email, password string) (int, http.Header) {
+		browser("")
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		form.Add("remember", "true")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		return code, header
+	}
+
+	// Browsers are only remembered when asked to.
+	browser("")
+	ts.login(t, "test@example.com")
+	assert.Equal(t, rememberToken(), "")
+
+	code, _ := login("test@example.com", "wrong")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, rememberToken(), "")
+
+	code, _ = login("test@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+	first := rememberToken()
+	assert.Equal(t, first != "", true)
+
+	// Once the session has gone, the token logs the browser back in and is
+	// replaced.
+	browser(first)
+	assert.Equal(t, loggedIn(), true)
+	second := rememberToken()
+	assert.Equal(t, second != "" && second != first, true)
+	assert.Equal(t, loggedIn(), true)
+	assert.Equal(t, rememberToken(), second)
+
+	laptop := browser(second)
+	assert.Equal(t, loggedIn(), true)
+	third := rememberToken()
+	assert.Equal(t, third != second, true)
+
+	// Unknown tokens are forgotten.
+	browser("AAAAAAAAAAAAAAAA.bogus")
+	assert.Equal(t, loggedIn(), false)
+	assert.Equal(t, rememberToken(), "")
+
+	// A token sent again just after it was used, as by two requests which
+	// the browser sent at once, isn't taken for a replay. The request isn't
+	// logged in, and the cookie is left alone.
+	count := tokens.Count(1)
+	browser(second)
+	assert.Equal(t, loggedIn(), false)
+	assert.Equal(t, rememberToken(), second)
+	assert.Equal(t, tokens.Count(1), count)
+	ts.Client().Jar = laptop
+	assert.Equal(t, loggedIn(), true)
+
+	// Once the grace period has passed, using an old token again logs out
+	// all of the user's sessions and tokens, as it has probably been stolen.
+	tokens.Age(rememberReuseGrace)
+	browser(first)
+	assert.Equal(t, loggedIn(), false)
+	assert.Equal(t, rememberToken(), "")
+	assert.Equal(t, tokens.Count(1), 0)
+	ts.Client().Jar = laptop
+	assert.Equal(t, loggedIn(), false)
+	browser(third)
+	assert.Equal(t, loggedIn(), false)
+
+	// Logging out forgets the token.
+	login("test@example.com", "pa$$word")
+	token := rememberToken()
+	_, _, body := ts.get(t, "/")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.postForm(t, "/user/logout", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, rememberToken(), "")
+	assert.Equal(t, tokens.Count(1), 0)
+	browser(token)
+	assert.Equal(t, loggedIn(), false)
+
+	// With two-factor authentication, the browser is remembered once the
+	// code has been entered.
+	enableTwoFactor(t, app, 2)
+	code, header := login("other@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+	assert.Equal(t, rememberToken(), "")
+	_, _, body = ts.get(t, "/user/login/2fa")
+	form = url.Values{}
+	form.Add("code", "aaaa-bbbb")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	token = rememberToken()
+	assert.Equal(t, token != "", true)
+	browser(token)
+	assert.Equal(t, loggedIn(), true)
+}
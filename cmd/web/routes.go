@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com.scottyfionnghall.snippetbox/internal/models"
 	"github.com.scottyfionnghall.snippetbox/ui"
 
 	"github.com/julienschmidt/httprouter"
@@ -12,7 +13,7 @@ import (
 
 // The routes method returns a servemux containing out application routes
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := tracedRouter{httprouter.New()}
 
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w)
@@ -25,28 +26,117 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/static/*filepath", neuter(fileServer))
 
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	// Feeds are public and don't need a session. Per-user routes live under
+	// /users because httprouter can't mix /user/:id with /user/login.
+	router.HandlerFunc(http.MethodGet, "/feed.atom", app.feedLatest("atom"))
+	router.HandlerFunc(http.MethodGet, "/feed.rss", app.feedLatest("rss"))
+	router.HandlerFunc(http.MethodGet, "/users/:id/feed.atom", app.feedUser("atom"))
+	router.HandlerFunc(http.MethodGet, "/users/:id/feed.rss", app.feedUser("rss"))
 	// Create a new middleware chain containing the middleware specific to our
 	// dynamic application routes.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.restoreSession, app.authenticate)
 	// Define handlers containing dynamic iddlware chain
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
-	router.Handler(http.MethodDelete, "/snippet/delete", dynamic.ThenFunc(app.snippetDelete))
+	router.Handler(http.MethodGet, "/snippet/raw/:id", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/raw/:id/:name", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/zip/:id", dynamic.ThenFunc(app.snippetZip))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodGet, "/users/:id", dynamic.ThenFunc(app.userProfile))
+	router.HandlerFunc(http.MethodGet, "/users/:id/avatar", app.userAvatar)
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	router.Handler(http.MethodPost, "/user/login/passkey/begin", dynamic.ThenFunc(app.userPasskeyBeginPost))
+	router.Handler(http.MethodPost, "/user/login/passkey/finish", dynamic.ThenFunc(app.userPasskeyFinishPost))
+	router.Handler(http.MethodGet, "/user/login/sso", dynamic.ThenFunc(app.userLoginSSO))
+	router.Handler(http.MethodGet, "/user/login/sso/callback", dynamic.ThenFunc(app.userLoginSSOCallback))
+	router.Handler(http.MethodGet, "/user/forgot-password", dynamic.ThenFunc(app.userForgotPassword))
+	router.Handler(http.MethodPost, "/user/forgot-password", dynamic.ThenFunc(app.userForgotPasswordPost))
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.userVerify))
+	router.Handler(http.MethodGet, "/user/reset-password", dynamic.ThenFunc(app.userResetPassword))
+	router.Handler(http.MethodPost, "/user/reset-password", dynamic.ThenFunc(app.userResetPasswordPost))
 
 	protected := dynamic.Append(app.requireAuthentication)
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	// Creating snippets, including forks, may need a verified email address.
+	verified := protected.Append(app.requireVerified)
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create", verified.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/fork/:id", verified.ThenFunc(app.snippetForkPost))
+	router.Handler(http.MethodDelete, "/snippet/delete", protected.ThenFunc(app.snippetDelete))
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.snippetStarPost))
+	router.Handler(http.MethodPost, "/snippet/unstar/:id", protected.ThenFunc(app.snippetUnstarPost))
+	router.Handler(http.MethodGet, "/user/starred", protected.ThenFunc(app.userStarred))
+	router.Handler(http.MethodPost, "/snippet/comment/:id", protected.ThenFunc(app.commentCreatePost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	router.Handler(http.MethodPost, "/user/logout-everywhere", protected.ThenFunc(app.userLogoutEverywherePost))
+	router.Handler(http.MethodGet, "/account", protected.ThenFunc(app.account))
+	router.Handler(http.MethodPost, "/account/verify", protected.ThenFunc(app.accountVerifyPost))
+	router.Handler(http.MethodGet, "/account/name", protected.ThenFunc(app.accountName))
+	router.Handler(http.MethodPost, "/account/name", protected.ThenFunc(app.accountNamePost))
+	// Directory users' email addresses and passwords can't be changed here.
+	local := protected.Append(app.requireLocalAccount)
+	router.Handler(http.MethodGet, "/account/email", local.ThenFunc(app.accountEmail))
+	router.Handler(http.MethodPost, "/account/email", local.ThenFunc(app.accountEmailPost))
+	router.Handler(http.MethodGet, "/account/password", local.ThenFunc(app.accountPassword))
+	router.Handler(http.MethodPost, "/account/password", local.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodGet, "/account/2fa", protected.ThenFunc(app.accountTwoFactor))
+	router.Handler(http.MethodPost, "/account/2fa/setup", protected.ThenFunc(app.accountTwoFactorSetupPost))
+	router.Handler(http.MethodGet, "/account/2fa/enroll", protected.ThenFunc(app.accountTwoFactorEnroll))
+	router.Handler(http.MethodPost, "/account/2fa/enroll", protected.ThenFunc(app.accountTwoFactorEnrollPost))
+	router.Handler(http.MethodGet, "/account/2fa/qr.png", protected.ThenFunc(app.accountTwoFactorQR))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+	router.Handler(http.MethodPost, "/account/passkeys/begin", protected.ThenFunc(app.accountPasskeyBeginPost))
+	router.Handler(http.MethodPost, "/account/passkeys/finish", protected.ThenFunc(app.accountPasskeyFinishPost))
+	router.Handler(http.MethodPost, "/account/passkeys/delete/:id", protected.ThenFunc(app.accountPasskeyDeletePost))
+	router.Handler(http.MethodGet, "/account/profile", protected.ThenFunc(app.accountProfile))
+	// The profile form carries the avatar, so its size is limited before
+	// any middleware reads it.
+	profile := alice.New(app.limitBody(maxProfileFormSize)).Extend(protected)
+	router.Handler(http.MethodPost, "/account/profile", profile.ThenFunc(app.accountProfilePost))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodPost, "/account/sessions/:id/revoke", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodGet, "/account/tokens", protected.ThenFunc(app.accountTokens))
+	router.Handler(http.MethodPost, "/account/tokens", protected.ThenFunc(app.accountTokensPost))
+	router.Handler(http.MethodPost, "/account/tokens/:id/revoke", protected.ThenFunc(app.accountTokenRevokePost))
+
+	// The JSON API has its own authentication and doesn't use sessions, so
+	// it doesn't go through the nosurf middleware.
+	api := alice.New(app.authenticateToken, app.authenticateAPI)
+	read := api.Append(app.requireScope(models.ScopeRead))
+	router.Handler(http.MethodGet, "/api/v1/snippets", read.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", read.ThenFunc(app.apiSnippetGet))
+
+	apiProtected := api.Append(app.requireAPIAuthentication)
+	write := apiProtected.Append(app.requireScope(models.ScopeWrite))
+	router.Handler(http.MethodPost, "/api/v1/snippets", write.Append(app.requireAPIVerified).ThenFunc(app.apiSnippetCreate))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id", write.ThenFunc(app.apiSnippetUpdate))
+	remove := apiProtected.Append(app.requireScope(models.ScopeDelete))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", remove.ThenFunc(app.apiSnippetDelete))
+
+	standard := alice.New(requestID, traceRequest, app.logRequest, app.recoverPanic, secureHeaders)
 
 	// Pass the servemux as the 'next' parameter to the secureHeaders middleware.
 	return standard.Then(router)
 }
 
+// tracedRouter wraps httprouter.Router so that every handler is registered
+// together with the nameSpan middleware for its route.
+type tracedRouter struct {
+	*httprouter.Router
+}
+
+func (tr tracedRouter) Handler(method, path string, handler http.Handler) {
+	tr.Router.Handler(method, path, nameSpan(path, handler))
+}
+
+func (tr tracedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	tr.Handler(method, path, handler)
+}
+
 // Disable directory listing for static directory
 func neuter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
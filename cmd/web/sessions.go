@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// How often the last-seen time of a session is updated.
+const sessionTouchInterval = time.Minute
+
+// The currentSessionID helper returns the ID of the current request's
+// logged-in session, or 0 if there is none.
+func (app *application) currentSessionID(r *http.Request) int {
+	return app.sessionManager.GetInt(r.Context(), "sessionID")
+}
+
+// The trackSession helper records the current session as a logged-in
+// session of the user with the given ID, so that it can be listed and
+// logged out from other devices.
+func (app *application) trackSession(r *http.Request, userID int) error {
+	userAgent := r.UserAgent()
+	if utf8.RuneCountInString(userAgent) > 255 {
+		userAgent = string([]rune(userAgent)[:255])
+	}
+	id, err := app.sessions.Insert(r.Context(), userID, clientIP(r), userAgent, app.sessionManager.Lifetime)
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "sessionID", id)
+	return nil
+}
+
+// The checkSession helper reports whether the current session is still
+// logged in as the user with the given ID, and records that it has been
+// seen. A session stops being logged in when it is logged out from another
+// device.
+func (app *application) checkSession(r *http.Request, userID int) (bool, error) {
+	id := app.currentSessionID(r)
+	if id == 0 {
+		// Sessions logged in before sessions were tracked can't be logged
+		// out from other devices, for example by a password change, so
+		// they have to log in again.
+		return false, nil
+	}
+
+	s, err := app.sessions.Get(r.Context(), id)
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if s.UserID != userID {
+		return false, nil
+	}
+	if time.Since(s.LastSeen) >= sessionTouchInterval {
+		err = app.sessions.Touch(r.Context(), id, clientIP(r))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// The logOut helper logs the current session out, and makes the browser
+// forget its remember me token. The session token is renewed, like when
+// logging in.
+func (app *application) logOut(w http.ResponseWriter, r *http.Request) error {
+	id := app.currentSessionID(r)
+	if id != 0 {
+		err := app.sessions.Delete(r.Context(), id, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+		err = app.rememberTokens.DeleteForSession(r.Context(), id)
+		if err != nil {
+			return err
+		}
+	}
+	forget(w)
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	// Remove the authenticatedUserID from the session data so that the user
+	// is "logged out"
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "sessionID")
+	return nil
+}
+
+// This handler shows the current user's logged-in sessions.
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.sessions.ListForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Sessions = sessions
+	data.CurrentSessionID = app.currentSessionID(r)
+	app.render(w, r, http.StatusOK, "sessions.html", data)
+}
+
+// This handler logs out one of the current user's sessions. Logging out the
+// current session works like the logout button.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	id := readIDParam(r)
+	if id == 0 {
+		app.notFound(w)
+		return
+	}
+	if id == app.currentSessionID(r) {
+		app.userLogoutPost(w, r)
+		return
+	}
+
+	err := app.sessions.Delete(r.Context(), id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.rememberTokens.DeleteForSession(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// This handler logs out every session of the current user, including the
+// current one, and deletes all of their remember me tokens.
+func (app *application) userLogoutEverywherePost(w http.ResponseWriter, r *http.Request) {
+	err := app.rememberTokens.DeleteForUser(r.Context(), app.authenticatedUserID(r), 0)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.sessions.DeleteForUser(r.Context(), app.authenticatedUserID(r), 0)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.logOut(w, r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out on all your devices.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Each browser has its own cookie jar, and is logged in as the given
+	// user. The returned function switches to the browser.
+	newBrowser := func(email string) (func(), string) {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		use := func() { ts.Client().Jar = jar }
+		use()
+		return use, ts.login(t, email)
+	}
+	loggedIn := func() bool {
+		code, _, _ := ts.get(t, "/snippet/create")
+		return code == http.StatusOK
+	}
+	post := func(urlPath, csrfToken string) (int, http.Header) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, urlPath, form)
+		return code, header
+	}
+
+	useLaptop, _ := newBrowser("test@example.com")
+	usePhone, phoneToken := newBrowser("test@example.com")
+	useOther, _ := newBrowser("other@example.com")
+
+	// The list shows both of the user's sessions, and marks the current one.
+	usePhone()
+	code, _, body := ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Count(body, "<td>127.0.0.1</td>"), 2)
+	assert.Equal(t, strings.Count(body, "This session"), 1)
+	assert.StringContains(t, body, `action="/account/sessions/1/revoke"`)
+	assert.Equal(t, strings.Contains(body, `action="/account/sessions/2/revoke"`), false)
+	assert.Equal(t, strings.Contains(body, `action="/account/sessions/3/revoke"`), false)
+
+	// Log out the laptop from the phone.
+	code, header := post("/account/sessions/1/revoke", phoneToken)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/sessions")
+	_, _, body = ts.get(t, "/account/sessions")
+	assert.StringContains(t, body, "The session has been logged out.")
+	assert.Equal(t, loggedIn(), true)
+	useLaptop()
+	assert.Equal(t, loggedIn(), false)
+
+	// Sessions can't be logged out twice, nor can other users' sessions.
+	usePhone()
+	code, _ = post("/account/sessions/1/revoke", phoneToken)
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _ = post("/account/sessions/3/revoke", phoneToken)
+	assert.Equal(t, code, http.StatusNotFound)
+	useOther()
+	assert.Equal(t, loggedIn(), true)
+
+	// Changing the password logs out the other sessions.
+	useLaptop, _ = newBrowser("test@example.com")
+	usePhone()
+	form := url.Values{}
+	form.Add("current_password", "pa$$word")
+	form.Add("new_password", "new-pa$$word")
+	form.Add("new_password_confirmation", "new-pa$$word")
+	form.Add("csrf_token", phoneToken)
+	code, _, _ = ts.postForm(t, "/account/password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, loggedIn(), true)
+	useLaptop()
+	assert.Equal(t, loggedIn(), false)
+
+	// Logging out everywhere includes the current session.
+	useLaptop, _ = newBrowser("test@example.com")
+	usePhone()
+	_, _, body = ts.get(t, "/")
+	code, header = post("/user/logout-everywhere", extractCSRFToken(t, body))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, body, "logged out on all your devices")
+	assert.Equal(t, loggedIn(), false)
+	useLaptop()
+	assert.Equal(t, loggedIn(), false)
+	useOther()
+	assert.Equal(t, loggedIn(), true)
+}
+
+func TestUntrackedSession(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// A session logged in before sessions were tracked has no session ID.
+	ctx, err := app.sessionManager.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.sessionManager.Put(ctx, "authenticatedUserID", 1)
+	token, _, err := app.sessionManager.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: app.sessionManager.Cookie.Name, Value: token}})
+	ts.Client().Jar = jar
+
+	// It has to log in again, rather than being tracked from now on.
+	code, header, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	sessions, err := app.sessions.ListForUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 0)
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"html/template"
 	"io/fs"
+	"net/url"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com.scottyfionnghall.snippetbox/internal/models"
 	"github.com.scottyfionnghall.snippetbox/ui"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
 )
 
 // Create a humanDate function which returns a nicely formatted string
@@ -19,8 +27,101 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// Regular expressions for the inline markup supported by markdownLite. They
+// run on text which has already been HTML-escaped.
+var (
+	codeSpanRX = regexp.MustCompile("`([^`\n]+)`")
+	boldRX     = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	italicRX   = regexp.MustCompile(`\*([^*\n]+)\*`)
+	// Links stop at escaped quotes, and may not contain asterisks so that
+	// the emphasis expressions can't match inside them.
+	linkRX = regexp.MustCompile(`https?://(?:[^\s<>&*]|&amp;)*[^\s<>&*.,;:!?)]`)
+)
+
+// The markdownLite function renders a comment as HTML. It supports
+// paragraphs, line breaks, `code`, **bold**, *italic* and bare http(s)
+// links. The text is escaped first and only fixed tags are added, so the
+// output never contains attributes, scripts or styles from the input.
+func markdownLite(s string) template.HTML {
+	var b strings.Builder
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	for _, para := range strings.Split(s, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		// Code spans are copied as they are, everything else gets the
+		// remaining inline markup.
+		escaped := template.HTMLEscapeString(para)
+		last := 0
+		for _, m := range codeSpanRX.FindAllStringSubmatchIndex(escaped, -1) {
+			b.WriteString(inlineMarkup(escaped[last:m[0]]))
+			b.WriteString("<code>" + escaped[m[2]:m[3]] + "</code>")
+			last = m[1]
+		}
+		b.WriteString(inlineMarkup(escaped[last:]))
+		b.WriteString("</p>")
+	}
+	return template.HTML(b.String())
+}
+
+func inlineMarkup(s string) string {
+	s = linkRX.ReplaceAllString(s, `<a href="$0" rel="nofollow noopener">$0</a>`)
+	s = boldRX.ReplaceAllString(s, "<strong>$1</strong>")
+	s = italicRX.ReplaceAllString(s, "<em>$1</em>")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+// The markdown converter supports GitHub flavoured markdown, including
+// tables. Raw HTML in the source is left out by goldmark.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// The markdownPolicy is the allow-list of elements and attributes kept in
+// rendered markdown. It is based on bluemonday's policy for user generated
+// content, which has no scripts, styles or event handler attributes, and
+// only allows the language classes goldmark adds to code fences.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9+#-]+$`)).OnElements("code")
+	p.AddTargetBlankToFullyQualifiedLinks(false)
+	return p
+}()
+
+// The renderMarkdown function converts markdown to HTML and sanitizes the
+// result. Only sanitized output is ever converted to template.HTML.
+func renderMarkdown(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := markdown.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Define a numberedLine type for one line of a file on the snippet page.
+type numberedLine struct {
+	Number int
+	Text   string
+}
+
+// The numberLines function splits a file into numbered lines, starting at 1.
+func numberLines(content string) []numberedLine {
+	lines := splitLines(content)
+	numbered := make([]numberedLine, len(lines))
+	for i, line := range lines {
+		numbered[i] = numberedLine{Number: i + 1, Text: line}
+	}
+	return numbered
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":    humanDate,
+	"languages":    func() []string { return languages },
+	"pathEscape":   url.PathEscape,
+	"markdownLite": markdownLite,
+	"numberLines":  numberLines,
+	"markdown":     renderMarkdown,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -68,4 +169,25 @@ type templateData struct {
 	Flash           string
 	IsAuthenticated bool
 	CSRFToken       string
+	APITokens       []*models.APIToken
+	NewAPIToken     string
+	Starred         bool
+	Pagination      paginationMetadata
+	User            *models.User
+	Comments        []commentRow
+	CommentOrder    string
+	// Two-factor authentication settings and the recovery codes shown
+	// once after enabling it.
+	TwoFactor          *models.TwoFactor
+	TwoFactorAvailable bool
+	TOTPSecret         string
+	RecoveryCodes      []string
+	RecoveryCodesLeft  int
+	Passkeys           []*models.Passkey
+	Sessions           []*models.UserSession
+	CurrentSessionID   int
+	// SSOEnabled shows the single sign-on button on the login page.
+	SSOEnabled bool
+	// SignupEnabled is false when accounts come from a directory.
+	SignupEnabled bool
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -39,3 +40,127 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestMarkdownLite(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Paragraphs",
+			input: "one\ntwo\n\nthree",
+			want:  "<p>one<br>two</p><p>three</p>",
+		},
+		{
+			name:  "Emphasis",
+			input: "**bold** and *italic*",
+			want:  "<p><strong>bold</strong> and <em>italic</em></p>",
+		},
+		{
+			name:  "Code",
+			input: "use `a *b* <c>`",
+			want:  "<p>use <code>a *b* &lt;c&gt;</code></p>",
+		},
+		{
+			name:  "Link",
+			input: "see https://example.com/a?b=1&c=2.",
+			want:  `<p>see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener">https://example.com/a?b=1&amp;c=2</a>.</p>`,
+		},
+		{
+			name:  "HTML",
+			input: `<script>alert("x")</script> "javascript:alert(1)"`,
+			want:  "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &#34;javascript:alert(1)&#34;</p>",
+		},
+		{
+			name:  "Quoted link",
+			input: `"https://example.com"`,
+			want:  `<p>&#34;<a href="https://example.com" rel="nofollow noopener">https://example.com</a>&#34;</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(markdownLite(tt.input)), tt.want)
+		})
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:  "Markup",
+			input: "# Title\n\n- item\n\n```go\nfmt.Println()\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n[link](https://example.com)",
+			want: []string{
+				"<h1>Title</h1>",
+				"<li>item</li>",
+				`<code class="language-go">`,
+				"<td>1</td>",
+				`<a href="https://example.com" rel="nofollow">link</a>`,
+			},
+		},
+		{
+			name:     "Script tag",
+			input:    "<script>alert(1)</script>\n\nok",
+			want:     []string{"<p>ok</p>"},
+			dontWant: []string{"<script", "alert"},
+		},
+		{
+			name:     "Event handler",
+			input:    "<img src=x onerror=alert(1)>",
+			dontWant: []string{"onerror", "alert"},
+		},
+		{
+			name:     "Style attribute",
+			input:    `<p style="color:red" onclick="x()">hi</p>`,
+			dontWant: []string{"style", "onclick"},
+		},
+		{
+			name:     "JavaScript link",
+			input:    "[x](javascript:alert(1))",
+			dontWant: []string{"javascript:"},
+		},
+		{
+			name:     "Code fence",
+			input:    "```html\n<script>alert(1)</script>\n```",
+			want:     []string{"&lt;script&gt;alert(1)&lt;/script&gt;"},
+			dontWant: []string{"<script"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := renderMarkdown(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				assert.StringContains(t, string(html), want)
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(string(html), dontWant) {
+					t.Errorf("got: %q; expected not to contain: %q", html, dontWant)
+				}
+			}
+		})
+	}
+}
+
+// The sanitizer must strip dangerous HTML on its own, whatever the markdown
+// converter lets through.
+func TestMarkdownPolicy(t *testing.T) {
+	input := `<p style="color:red" onclick="x()">hi</p><script>alert(1)</script>` +
+		`<a href="javascript:alert(1)">x</a><img src="x" onerror="alert(1)"><iframe src="https://example.com"></iframe>`
+
+	got := markdownPolicy.Sanitize(input)
+
+	for _, dontWant := range []string{"style", "onclick", "<script", "alert", "javascript:", "onerror", "<iframe"} {
+		if strings.Contains(got, dontWant) {
+			t.Errorf("got: %q; expected not to contain: %q", got, dontWant)
+		}
+	}
+	assert.StringContains(t, got, "<p>hi</p>")
+}
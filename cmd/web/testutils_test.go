@@ -2,18 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com.scottyfionnghall.snippetbox/internal/mailer"
 	"github.com.scottyfionnghall.snippetbox/internal/mocks"
+	"github.com.scottyfionnghall.snippetbox/internal/sealer"
+	"github.com.scottyfionnghall.snippetbox/internal/signer"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 )
@@ -22,7 +27,7 @@ type testServer struct {
 	*httptest.Server
 }
 
-var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
+var csrfTokenRX = regexp.MustCompile(`<input type=['"]hidden['"] name=['"]csrf_token['"] value=['"](.+?)['"]>`)
 
 func extractCSRFToken(t *testing.T, body string) string {
 	matches := csrfTokenRX.FindStringSubmatch(body)
@@ -76,18 +81,42 @@ func newTestApplication(t *testing.T) *application {
 
 	formDecoder := form.NewDecoder()
 
+	seal, err := sealer.New(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webAuthn, err := newWebAuthn("https://snippetbox.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
 	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
 		snippets:       &mocks.SnippetModel{},
 		users:          &mocks.UserModel{},
+		tokens:         &mocks.TokenModel{},
+		stars:          &mocks.StarModel{},
+		comments:       &mocks.CommentModel{},
+		resets:         &mocks.PasswordResetModel{},
+		twoFactor:      &mocks.TwoFactorModel{},
+		passkeys:       &mocks.PasskeyModel{},
+		logins:         &mocks.LoginAttemptModel{},
+		sessions:       &mocks.UserSessionModel{},
+		rememberTokens: &mocks.RememberTokenModel{},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         mailer.NewWriter(io.Discard, "no-reply@example.com"),
+		baseURL:        "https://snippetbox.test",
+		signer:         signer.New([]byte("test-secret")),
+		sealer:         seal,
+		webAuthn:       webAuthn,
+		verifyCooldown: newCooldown(verifyResendInterval),
 	}
 }
 
@@ -106,3 +135,83 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// The do method sends a request with the given method, body and headers to
+// the test server, for endpoints that aren't plain GETs or form POSTs.
+func (ts *testServer) do(t *testing.T, method, urlPath string, body io.Reader, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(b))
+}
+
+// The login method logs the test server's client in as the mock user with
+// the given email address, and returns a CSRF token for later form posts.
+func (ts *testServer) login(t *testing.T, email string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s failed with status %d", email, code)
+	}
+
+	_, _, body = ts.get(t, "/")
+	return extractCSRFToken(t, body)
+}
+
+// The tryLogin method posts the login form from a new browser with the
+// given credentials, and returns the response.
+func (ts *testServer) tryLogin(t *testing.T, email, password string) (int, http.Header, string) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	return ts.postForm(t, "/user/login", form)
+}
+
+// The enableTwoFactor helper turns on two-factor authentication for the
+// user with the given ID. The mock's recovery codes are aaaa-bbbb and
+// cccc-dddd.
+func enableTwoFactor(t *testing.T, app *application, userID int) {
+	secret, err := app.sealer.Seal([]byte("JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.twoFactor.SetSecret(context.Background(), userID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.twoFactor.Enable(context.Background(), userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+	"github.com.scottyfionnghall.snippetbox/internal/validator"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// Settings for time-based one-time passwords. They are the defaults which
+// every authenticator app supports.
+const (
+	totpIssuer = "Snippetbox"
+	totpPeriod = 30
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// A login waiting for a two-factor code expires after a few minutes, or
+// after as many wrong codes as the login throttle allows before backing
+// off. Wrong codes count towards the throttle too, so logging in with the
+// password again doesn't allow more guesses.
+const (
+	twoFactorLoginTTL      = 5 * time.Minute
+	twoFactorLoginAttempts = loginAccountFree
+)
+
+// Define a twoFactorCodeForm struct for entering a code from an
+// authenticator app, or a recovery code at login.
+type twoFactorCodeForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// Define a twoFactorDisableForm struct for turning two-factor
+// authentication off, which needs the current password.
+type twoFactorDisableForm struct {
+	CurrentPassword     string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+// The checkTOTP function reports whether code is valid for secret at the
+// given time. Codes from the time steps either side of now are accepted to
+// allow for clock drift. It returns the time step the code belongs to.
+func checkTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	for _, skew := range []int64{0, -1, 1} {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		want, err := totp.GenerateCodeCustom(secret, t, totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return t.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// The totpKey helper decrypts a stored secret and returns it as a key for
+// the given account, which can be shown as an otpauth:// URL or QR code.
+func (app *application) totpKey(tf *models.TwoFactor, account string) (*otp.Key, error) {
+	if app.sealer == nil {
+		return nil, errors.New("two-factor authentication needs an encryption key")
+	}
+	secret, err := app.sealer.Open(tf.Secret)
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("secret", string(secret))
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", "6")
+	v.Set("period", "30")
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return otp.NewKeyFromURL(u.String())
+}
+
+// This handler shows the current user's two-factor authentication settings.
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	tf, err := app.twoFactor.Get(r.Context(), userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.TwoFactor = tf
+	data.TwoFactorAvailable = app.sealer != nil
+	if tf != nil && tf.Enabled {
+		data.RecoveryCodesLeft, err = app.twoFactor.RecoveryCodesLeft(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	data.Form = twoFactorDisableForm{}
+	app.render(w, r, http.StatusOK, "twofactor.html", data)
+}
+
+// This handler starts enrolling the current user by storing a new secret,
+// which isn't used until the user has entered a first code.
+func (app *application) accountTwoFactorSetupPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if app.sealer == nil {
+		app.notFound(w)
+		return
+	}
+	tf, err := app.twoFactor.Get(r.Context(), userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if tf != nil && tf.Enabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Email})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	sealed, err := app.sealer.Seal([]byte(key.Secret()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.twoFactor.SetSecret(r.Context(), userID, sealed)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa/enroll", http.StatusSeeOther)
+}
+
+// The pendingTwoFactor helper returns the current user's unfinished
+// enrolment, or nil after redirecting back to the settings page if there
+// isn't one.
+func (app *application) pendingTwoFactor(w http.ResponseWriter, r *http.Request) *models.TwoFactor {
+	tf, err := app.twoFactor.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return nil
+	}
+	if tf == nil || tf.Enabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return nil
+	}
+	return tf
+}
+
+// This handler shows the QR code and secret for an unfinished enrolment,
+// with a form to enter the first code.
+func (app *application) accountTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	tf := app.pendingTwoFactor(w, r)
+	if tf == nil {
+		return
+	}
+	app.renderTwoFactorEnroll(w, r, http.StatusOK, tf, twoFactorCodeForm{})
+}
+
+func (app *application) renderTwoFactorEnroll(w http.ResponseWriter, r *http.Request, status int, tf *models.TwoFactor, form twoFactorCodeForm) {
+	key, err := app.totpKey(tf, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.TOTPSecret = key.Secret()
+	data.Form = form
+	app.render(w, r, status, "twofactor_enroll.html", data)
+}
+
+// This handler sends the QR code of an unfinished enrolment as a PNG image,
+// for scanning with an authenticator app.
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	tf := app.pendingTwoFactor(w, r)
+	if tf == nil {
+		return
+	}
+	user, err := app.users.Get(r.Context(), tf.UserID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	key, err := app.totpKey(tf, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	img, err := key.Image(240, 240)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	err = png.Encode(w, img)
+	if err != nil {
+		app.logger.Error(err.Error(), "request_id", requestIDFromContext(r.Context()))
+	}
+}
+
+// This handler finishes enrolling once the user has entered a valid code,
+// and shows their recovery codes once.
+func (app *application) accountTwoFactorEnrollPost(w http.ResponseWriter, r *http.Request) {
+	tf := app.pendingTwoFactor(w, r)
+	if tf == nil {
+		return
+	}
+
+	var form twoFactorCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	key, err := app.totpKey(tf, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	step, ok := checkTOTP(key.Secret(), form.Code, time.Now())
+	if !ok {
+		form.AddFieldError("code", "The code is incorrect. Check the time on your device and try again")
+		app.renderTwoFactorEnroll(w, r, http.StatusUnprocessableEntity, tf, form)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(r.Context(), tf.UserID, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Flash = "Two-factor authentication is now enabled."
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "recovery_codes.html", data)
+}
+
+// This handler turns two-factor authentication off after checking the
+// current password.
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	var form twoFactorDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.checkCurrentPassword(r, &form.Validator, form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		tf, err := app.twoFactor.Get(r.Context(), userID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.TwoFactor = tf
+		data.TwoFactorAvailable = app.sealer != nil
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "twofactor.html", data)
+		return
+	}
+
+	err = app.twoFactor.Disable(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off.")
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+// The twoFactorEnabled helper reports whether the user with the given ID
+// has to enter a code after their password.
+func (app *application) twoFactorEnabled(ctx context.Context, id int) (bool, error) {
+	tf, err := app.twoFactor.Get(ctx, id)
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return tf.Enabled, nil
+}
+
+// The startTwoFactorLogin helper records in the session that the user with
+// the given ID has entered their password, but still has to enter a code.
+// The user isn't logged in until then, and is only remembered afterwards if
+// remember is true.
+func (app *application) startTwoFactorLogin(r *http.Request, id int, remember bool) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+	app.sessionManager.Put(r.Context(), "twoFactorExpiry", time.Now().Add(twoFactorLoginTTL).Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorAttempts", 0)
+	app.sessionManager.Put(r.Context(), "twoFactorRemember", remember)
+	return nil
+}
+
+// The twoFactorLoginUserID helper returns the ID of the user waiting to
+// enter a code, or 0 if there is no such login or it has expired.
+func (app *application) twoFactorLoginUserID(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	if id == 0 || time.Now().Unix() >= app.sessionManager.GetInt64(r.Context(), "twoFactorExpiry") {
+		return 0
+	}
+	return id
+}
+
+func (app *application) endTwoFactorLogin(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpiry")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+	app.sessionManager.Remove(r.Context(), "twoFactorRemember")
+}
+
+// This handler shows the second login step, asking for a code.
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.twoFactorLoginUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorCodeForm{}
+	app.render(w, r, http.StatusOK, "login_2fa.html", data)
+}
+
+// This handler checks the code from an authenticator app, or a recovery
+// code, and only then logs the user in. After too many wrong codes the user
+// has to start again with their password. Wrong codes are also recorded as
+// failed logins, so that the login throttle limits guessing codes across
+// password logins.
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.twoFactorLoginUserID(r)
+	if id == 0 {
+		app.endTwoFactorLogin(r)
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorCodeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	tf, err := app.twoFactor.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	key, err := app.totpKey(tf, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	attempt, retryAt, err := app.startLogin(r, user.Email)
+	if err != nil {
+		if errors.Is(err, errTooManyAttempts) {
+			form.AddNonFieldError(loginLockedOutMsg)
+			data := app.newTemplateData(r)
+			data.Form = form
+			setRetryAfter(w, retryAt)
+			app.render(w, r, http.StatusTooManyRequests, "login_2fa.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Six digits are a code from the authenticator app, anything else may
+	// be a recovery code. Each code can only be used once.
+	var ok, usedRecoveryCode bool
+	code := strings.ReplaceAll(form.Code, " ", "")
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		var step int64
+		step, ok = checkTOTP(key.Secret(), code, time.Now())
+		if ok {
+			ok, err = app.twoFactor.UseStep(r.Context(), id, step)
+		}
+	} else if validator.NotBlank(code) {
+		ok, err = app.twoFactor.UseRecoveryCode(r.Context(), id, strings.ToLower(code))
+		usedRecoveryCode = ok
+	}
+	result := models.LoginSucceeded
+	if err != nil {
+		result = models.LoginError
+	} else if !ok {
+		result = models.LoginFailed
+	}
+	finishErr := app.logins.Finish(r.Context(), attempt, result)
+	if err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= twoFactorLoginAttempts {
+			app.endTwoFactorLogin(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		form.AddFieldError("code", "The code is incorrect")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login_2fa.html", data)
+		return
+	}
+
+	remember := app.sessionManager.GetBool(r.Context(), "twoFactorRemember")
+	app.endTwoFactorLogin(r)
+	err = app.logIn(w, r, id, remember)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if usedRecoveryCode {
+		left, err := app.twoFactor.RecoveryCodesLeft(r.Context(), id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("You used a recovery code. You have %d left.", left))
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
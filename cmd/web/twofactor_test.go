@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestCheckTOTP(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	now := time.Unix(1710670500, 0)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "Current step", at: now, wantStep: now.Unix() / 30, wantOK: true},
+		{name: "Previous step", at: now.Add(-30 * time.Second), wantStep: now.Unix()/30 - 1, wantOK: true},
+		{name: "Next step", at: now.Add(30 * time.Second), wantStep: now.Unix()/30 + 1, wantOK: true},
+		{name: "Too old", at: now.Add(-90 * time.Second)},
+		{name: "Too new", at: now.Add(90 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := totp.GenerateCodeCustom(secret, tt.at, totpOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := checkTOTP(secret, code, now)
+
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+var totpSecretRX = regexp.MustCompile(`Key: <code>([A-Z2-7]+)</code>`)
+
+func TestTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Enrol the test user.
+	csrfToken := ts.login(t, "test@example.com")
+	_, _, body := ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, "Set up two-factor authentication")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, header, _ := ts.postForm(t, "/account/2fa/setup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/2fa/enroll")
+
+	code, _, body = ts.get(t, "/account/2fa/enroll")
+	assert.Equal(t, code, http.StatusOK)
+	matches := totpSecretRX.FindStringSubmatch(body)
+	if matches == nil {
+		t.Fatal("no TOTP secret found in body")
+	}
+	secret := matches[1]
+
+	code, header, _ = ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "image/png")
+
+	form.Set("code", "000000")
+	code, _, body = ts.postForm(t, "/account/2fa/enroll", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "The code is incorrect")
+
+	now := time.Now()
+	totpCode, err := totp.GenerateCodeCustom(secret, now, totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("code", totpCode)
+	code, _, body = ts.postForm(t, "/account/2fa/enroll", form)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "aaaa-bbbb")
+
+	// Logging in again now needs a code, and the password alone doesn't
+	// log the user in.
+	logInWithPassword := func() string {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Client().Jar = jar
+
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "test@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, header, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+
+		_, _, body = ts.get(t, "/user/login/2fa")
+		return extractCSRFToken(t, body)
+	}
+
+	csrfToken = logInWithPassword()
+	code, header, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	// The code used for enrolling can't be used again.
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Set("code", totpCode)
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	totpCode, err = totp.GenerateCodeCustom(secret, now.Add(30*time.Second), totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("code", totpCode)
+	code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/create")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Too many wrong codes end the login.
+	csrfToken = logInWithPassword()
+	form.Set("csrf_token", csrfToken)
+	form.Set("code", "000000")
+	for i := 1; i < twoFactorLoginAttempts; i++ {
+		code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	code, _, _ = ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// The wrong codes count as failed logins, so logging in with the
+	// password again doesn't allow more guesses until the back-off is over.
+	code, _, body = ts.tryLogin(t, "test@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.StringContains(t, body, "Too many failed login attempts.")
+	time.Sleep(loginBaseDelay)
+
+	// The right password doesn't clear the failures either, as the login
+	// isn't complete without the code.
+	csrfToken = logInWithPassword()
+	form.Set("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	code, header, body = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "2")
+	assert.StringContains(t, body, "Too many failed login attempts.")
+	time.Sleep(2 * loginBaseDelay)
+
+	// A recovery code works once.
+	csrfToken = logInWithPassword()
+	form.Set("csrf_token", csrfToken)
+	form.Set("code", "AAAA-BBBB")
+	code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/create")
+	_, _, body = ts.get(t, "/snippet/create")
+	assert.StringContains(t, body, "You used a recovery code. You have 1 left.")
+
+	csrfToken = logInWithPassword()
+	form.Set("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	// Turning two-factor authentication off needs the current password.
+	form.Set("code", "cccc-dddd")
+	ts.postForm(t, "/user/login/2fa", form)
+	_, _, body = ts.get(t, "/account/2fa")
+	csrfToken = extractCSRFToken(t, body)
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	form.Add("current_password", "wrong")
+	code, _, _ = ts.postForm(t, "/account/2fa/disable", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	form.Set("current_password", "pa$$word")
+	code, _, _ = ts.postForm(t, "/account/2fa/disable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, "Set up two-factor authentication")
+}
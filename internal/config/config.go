@@ -0,0 +1,213 @@
+// Package config holds the settings shared by the snippetbox commands, so
+// that cmd/web and cmd/snippetadmin read them in the same way.
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// Define a Config type to hold the settings. Every field can be set with a
+// command-line flag, and defaults to the matching SNIPPETBOX_* environment
+// variable if there is one.
+type Config struct {
+	Addr          string
+	DSN           string
+	LogFormat     string
+	TraceExporter string
+	OTLPEndpoint  string
+	BaseURL       string
+	Mailer        string
+	MailFile      string
+	MailSender    string
+	SMTPAddr      string
+	SMTPUsername  string
+	SMTPPassword  string
+	SecretKey     string
+	EncryptionKey string
+	// RequireVerified blocks creating snippets until the user has verified
+	// their email address.
+	RequireVerified bool
+	// Single sign-on with an OpenID Connect provider, which is turned off
+	// unless OIDCIssuer is set. OIDCAllowedDomains is a comma-separated
+	// list of email domains which may log in; if it is empty, any verified
+	// address may.
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCScopes         string
+	OIDCAllowedDomains string
+	// Password logins against an LDAP directory, which are turned off
+	// unless LDAPURL is set. LDAPLocalUsers is a comma-separated list of
+	// email addresses which still log in with their local password, so
+	// that admins can get in when the directory is down.
+	LDAPURL          string
+	LDAPStartTLS     bool
+	LDAPBindDN       string
+	LDAPBindPassword string
+	LDAPBaseDN       string
+	LDAPFilter       string
+	LDAPNameAttr     string
+	LDAPEmailAttr    string
+	LDAPGroupDN      string
+	LDAPLocalUsers   string
+	// How new passwords are hashed, either bcrypt or argon2id, and the
+	// parameters of each. Argon2Memory is in KiB.
+	PasswordHash  string
+	BcryptCost    int
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+}
+
+// Load registers the configuration flags on fs and parses args. Arguments
+// after the flags are left in fs.Args().
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := &Config{}
+
+	// Command-line argument "addr" to define address on wich the server
+	// will be listening.
+	fs.StringVar(&cfg.Addr, "addr", env("ADDR", ":8080"), "HTTP network address")
+	// Define command-line flag for the MySQL DSN string.
+	fs.StringVar(&cfg.DSN, "dsn", env("DSN", "web:pass@/snippetbox?parseTime=true"),
+		"MySQL data source name")
+	// Define command-line flag for the log output format, either "text"
+	// or "json".
+	fs.StringVar(&cfg.LogFormat, "log-format", env("LOG_FORMAT", "text"), "Log output format (text|json)")
+	// Define command-line flags for exporting tracing spans.
+	fs.StringVar(&cfg.TraceExporter, "trace-exporter", env("TRACE_EXPORTER", "none"), "Trace exporter (none|stdout|otlp)")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", env("OTLP_ENDPOINT", "localhost:4318"), "OTLP/HTTP collector address")
+	// Define command-line flag for the public address of the site, used for
+	// links in emails.
+	fs.StringVar(&cfg.BaseURL, "base-url", env("BASE_URL", "https://localhost:8080"), "Public base URL of the site")
+	// Define command-line flags for sending emails. The log and file
+	// mailers don't send anything and are meant for development.
+	fs.StringVar(&cfg.Mailer, "mailer", env("MAILER", "log"), "How emails are sent (log|file|smtp)")
+	fs.StringVar(&cfg.MailFile, "mail-file", env("MAIL_FILE", "mail.txt"), "File the file mailer appends emails to")
+	fs.StringVar(&cfg.MailSender, "mail-sender", env("MAIL_SENDER", "Snippetbox <no-reply@localhost>"), "Sender address of emails")
+	fs.StringVar(&cfg.SMTPAddr, "smtp-addr", env("SMTP_ADDR", "localhost:25"), "SMTP server address")
+	fs.StringVar(&cfg.SMTPUsername, "smtp-username", env("SMTP_USERNAME", ""), "SMTP username")
+	fs.StringVar(&cfg.SMTPPassword, "smtp-password", env("SMTP_PASSWORD", ""), "SMTP password")
+	// Define command-line flag for the key which signs links in emails. It
+	// should be a long random string, kept the same across restarts.
+	fs.StringVar(&cfg.SecretKey, "secret-key", env("SECRET_KEY", ""), "Secret key for signed links")
+	// Define command-line flag for the key which encrypts two-factor
+	// authentication secrets. Without it two-factor authentication can't be
+	// enabled.
+	fs.StringVar(&cfg.EncryptionKey, "encryption-key", env("ENCRYPTION_KEY", ""),
+		"Key for encrypting stored secrets, as 64 hex characters")
+	fs.BoolVar(&cfg.RequireVerified, "require-verified", envBool("REQUIRE_VERIFIED", false),
+		"Require a verified email address to create snippets")
+
+	// Define command-line flags for single sign-on. The provider must
+	// redirect back to <base-url>/user/login/sso/callback.
+	fs.StringVar(&cfg.OIDCIssuer, "oidc-issuer", env("OIDC_ISSUER", ""), "OpenID Connect issuer URL for single sign-on")
+	fs.StringVar(&cfg.OIDCClientID, "oidc-client-id", env("OIDC_CLIENT_ID", ""), "OpenID Connect client ID")
+	fs.StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", env("OIDC_CLIENT_SECRET", ""), "OpenID Connect client secret")
+	fs.StringVar(&cfg.OIDCScopes, "oidc-scopes", env("OIDC_SCOPES", "openid email profile"),
+		"Space-separated OpenID Connect scopes")
+	fs.StringVar(&cfg.OIDCAllowedDomains, "oidc-allowed-domains", env("OIDC_ALLOWED_DOMAINS", ""),
+		"Comma-separated email domains allowed to log in with single sign-on")
+
+	// Define command-line flags for logging in against an LDAP directory.
+	// Every %s in the filter is replaced by the email address entered on
+	// the login form.
+	fs.StringVar(&cfg.LDAPURL, "ldap-url", env("LDAP_URL", ""), "LDAP server URL for directory logins")
+	fs.BoolVar(&cfg.LDAPStartTLS, "ldap-starttls", envBool("LDAP_STARTTLS", false), "Upgrade ldap:// connections with StartTLS")
+	fs.StringVar(&cfg.LDAPBindDN, "ldap-bind-dn", env("LDAP_BIND_DN", ""), "LDAP service account DN used for searching")
+	fs.StringVar(&cfg.LDAPBindPassword, "ldap-bind-password", env("LDAP_BIND_PASSWORD", ""), "LDAP service account password")
+	fs.StringVar(&cfg.LDAPBaseDN, "ldap-base-dn", env("LDAP_BASE_DN", ""), "LDAP base DN to search for users")
+	fs.StringVar(&cfg.LDAPFilter, "ldap-filter", env("LDAP_FILTER", "(mail=%s)"), "LDAP filter selecting the user's entry")
+	fs.StringVar(&cfg.LDAPNameAttr, "ldap-name-attr", env("LDAP_NAME_ATTR", "cn"), "LDAP attribute holding the user's name")
+	fs.StringVar(&cfg.LDAPEmailAttr, "ldap-email-attr", env("LDAP_EMAIL_ATTR", "mail"), "LDAP attribute holding the user's email address")
+	fs.StringVar(&cfg.LDAPGroupDN, "ldap-group-dn", env("LDAP_GROUP_DN", ""), "LDAP group whose members may log in")
+	fs.StringVar(&cfg.LDAPLocalUsers, "ldap-local-users", env("LDAP_LOCAL_USERS", ""),
+		"Comma-separated email addresses which log in with local passwords")
+
+	// Define command-line flags for hashing passwords. Stored hashes made
+	// with another algorithm or a lower cost are replaced when their user
+	// next logs in.
+	def := models.DefaultPasswordHasher()
+	fs.StringVar(&cfg.PasswordHash, "password-hash", env("PASSWORD_HASH", def.Algorithm), "Password hashing algorithm (bcrypt|argon2id)")
+	fs.IntVar(&cfg.BcryptCost, "bcrypt-cost", envInt("BCRYPT_COST", def.BcryptCost), "bcrypt cost")
+	fs.IntVar(&cfg.Argon2Time, "argon2-time", envInt("ARGON2_TIME", int(def.Argon2Time)), "argon2id number of passes")
+	fs.IntVar(&cfg.Argon2Memory, "argon2-memory", envInt("ARGON2_MEMORY", int(def.Argon2Memory)), "argon2id memory in KiB")
+	fs.IntVar(&cfg.Argon2Threads, "argon2-threads", envInt("ARGON2_THREADS", int(def.Argon2Threads)), "argon2id degree of parallelism")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// env returns the value of the SNIPPETBOX_<key> environment variable, or
+// defaultValue if it isn't set.
+func env(key, defaultValue string) string {
+	value, ok := os.LookupEnv("SNIPPETBOX_" + strings.ToUpper(key))
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+// envInt is like env for integer settings. Values which can't be parsed
+// are ignored.
+func envInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(env(key, strconv.Itoa(defaultValue)))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// envBool is like env for boolean settings. Values which can't be parsed
+// are ignored.
+func envBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(env(key, strconv.FormatBool(defaultValue)))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// PasswordHasher returns the configured PasswordHasher, or an error if the
+// algorithm or its parameters are invalid.
+func (c *Config) PasswordHasher() (*models.PasswordHasher, error) {
+	if c.Argon2Time < 0 || c.Argon2Memory < 0 || c.Argon2Threads < 0 || c.Argon2Threads > 255 {
+		return nil, errors.New("argon2id parameters are out of range")
+	}
+	h := models.DefaultPasswordHasher()
+	h.Algorithm = c.PasswordHash
+	h.BcryptCost = c.BcryptCost
+	h.Argon2Time = uint32(c.Argon2Time)
+	h.Argon2Memory = uint32(c.Argon2Memory)
+	h.Argon2Threads = uint8(c.Argon2Threads)
+	err := h.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// The OpenDB() function wraps sql.Open() and return a sql.DB connection pool
+// for a given DSN
+func OpenDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
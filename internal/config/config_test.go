@@ -0,0 +1,68 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestLoad(t *testing.T) {
+	t.Setenv("SNIPPETBOX_DSN", "env:pass@/snippetbox")
+	t.Setenv("SNIPPETBOX_LOG_FORMAT", "json")
+	t.Setenv("SNIPPETBOX_REQUIRE_VERIFIED", "true")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg, err := Load(fs, []string{"-log-format", "text", "stats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Environment variables replace the defaults, and flags win over both.
+	assert.Equal(t, cfg.Addr, ":8080")
+	assert.Equal(t, cfg.DSN, "env:pass@/snippetbox")
+	assert.Equal(t, cfg.LogFormat, "text")
+	assert.Equal(t, cfg.RequireVerified, true)
+	assert.Equal(t, fs.Arg(0), "stats")
+}
+
+func TestPasswordHasher(t *testing.T) {
+	t.Setenv("SNIPPETBOX_PASSWORD_HASH", "argon2id")
+
+	load := func(args ...string) (*Config, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		return Load(fs, args)
+	}
+
+	cfg, err := load("-argon2-memory", "65536")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := cfg.PasswordHasher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, h.Algorithm, "argon2id")
+	assert.Equal(t, h.Argon2Memory, uint32(65536))
+	assert.Equal(t, h.Argon2Time, uint32(2))
+	assert.Equal(t, h.BcryptCost, 12)
+
+	for _, args := range [][]string{
+		{"-password-hash", "sha1"},
+		{"-password-hash", "bcrypt", "-bcrypt-cost", "40"},
+		{"-argon2-threads", "256"},
+		{"-argon2-time", "-1"},
+	} {
+		cfg, err := load(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cfg.PasswordHasher()
+		if err == nil {
+			t.Errorf("%v: got no error", args)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package directory authenticates users against an LDAP directory. A
+// service account searches for the user's entry, and the user's password is
+// then checked by binding as that entry.
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// ErrInvalidCredentials is returned if there is no entry for the
+	// login, or the password is wrong.
+	ErrInvalidCredentials = errors.New("directory: invalid credentials")
+	// ErrNotAllowed is returned if the password is right, but the user
+	// isn't a member of the required group.
+	ErrNotAllowed = errors.New("directory: user is not in the required group")
+)
+
+// Define a Directory type to hold the settings for an LDAP directory.
+type Directory struct {
+	// URL is the address of the server, e.g. ldap://ldap.example.com:389
+	// or ldaps://ldap.example.com:636.
+	URL string
+	// StartTLS upgrades an ldap:// connection to TLS before anything is
+	// sent.
+	StartTLS bool
+	// TLSConfig is used for ldaps:// and StartTLS. If it is nil, the
+	// server's certificate is checked against the system roots.
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the service account used for searching.
+	// If BindDN is empty, the search is anonymous.
+	BindDN       string
+	BindPassword string
+	// BaseDN is where the search for users starts, and Filter selects the
+	// user's entry. Every %s in Filter is replaced by the escaped login,
+	// e.g. (&(objectClass=person)(mail=%s)).
+	BaseDN string
+	Filter string
+	// NameAttr and EmailAttr are the attributes holding the user's name
+	// and email address.
+	NameAttr  string
+	EmailAttr string
+	// If GroupDN is set, only members of that group may log in. Membership
+	// is read from the user's memberOf attribute.
+	GroupDN string
+	// Timeout limits each network operation. It defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Define an Entry type for the user found in the directory.
+type Entry struct {
+	DN    string
+	Name  string
+	Email string
+}
+
+// Authenticate checks login and password against the directory and returns
+// the user's entry. A login matching no entry, or more than one, is treated
+// like a wrong password.
+func (d *Directory) Authenticate(ctx context.Context, login, password string) (*Entry, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.BindDN != "" {
+		err = conn.Bind(d.BindDN, d.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("directory: service account bind: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.timeout().Seconds()), false,
+		strings.ReplaceAll(d.Filter, "%s", ldap.EscapeFilter(login)),
+		[]string{d.NameAttr, d.EmailAttr, "memberOf"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("directory: search: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	err = conn.Bind(found.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("directory: user bind: %w", err)
+	}
+
+	if d.GroupDN != "" && !containsFold(found.GetAttributeValues("memberOf"), d.GroupDN) {
+		return nil, ErrNotAllowed
+	}
+
+	return &Entry{
+		DN:    found.DN,
+		Name:  found.GetAttributeValue(d.NameAttr),
+		Email: found.GetAttributeValue(d.EmailAttr),
+	}, nil
+}
+
+func (d *Directory) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return d.Timeout
+}
+
+// The dial method connects to the server, and upgrades the connection with
+// StartTLS if configured.
+func (d *Directory) dial(ctx context.Context) (*ldap.Conn, error) {
+	timeout := d.timeout()
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	conn, err := ldap.DialURL(d.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(d.tlsConfig()))
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if d.StartTLS {
+		err = conn.StartTLS(d.tlsConfig())
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("directory: starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// The tlsConfig method returns the TLS settings, with the server name
+// taken from the URL if it isn't set.
+func (d *Directory) tlsConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if d.TLSConfig != nil {
+		cfg = d.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		u, err := url.Parse(d.URL)
+		if err == nil {
+			cfg.ServerName = u.Hostname()
+		}
+	}
+	return cfg
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+	"github.com.scottyfionnghall.snippetbox/internal/ldaptest"
+)
+
+const (
+	serviceDN = "cn=snippetbox,ou=services,dc=example,dc=com"
+	groupDN   = "cn=writers,ou=groups,dc=example,dc=com"
+)
+
+func newTestServer(t *testing.T) *ldaptest.Server {
+	s, err := ldaptest.NewServer(
+		&ldaptest.Entry{DN: serviceDN, Password: "service"},
+		&ldaptest.Entry{
+			DN:       "uid=alice,ou=people,dc=example,dc=com",
+			Password: "alice-pw",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"alice"},
+				"cn":          {"Alice Jones"},
+				"mail":        {"alice@example.com"},
+				"memberOf":    {"CN=Writers,OU=Groups,DC=example,DC=com"},
+			},
+		},
+		&ldaptest.Entry{
+			DN:       "uid=bob,ou=people,dc=example,dc=com",
+			Password: "bob-pw",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"bob"},
+				"cn":          {"Bob Smith"},
+				"mail":        {"bob@example.com", "shared@example.com"},
+			},
+		},
+		&ldaptest.Entry{
+			DN:       "uid=carol,ou=people,dc=example,dc=com",
+			Password: "carol-pw",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"carol"},
+				"cn":          {"Carol White"},
+				"mail":        {"shared@example.com"},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	tests := []struct {
+		name      string
+		groupDN   string
+		login     string
+		password  string
+		wantEntry *Entry
+		wantErr   error
+	}{
+		{
+			name:     "Valid",
+			login:    "alice@example.com",
+			password: "alice-pw",
+			wantEntry: &Entry{
+				DN:    "uid=alice,ou=people,dc=example,dc=com",
+				Name:  "Alice Jones",
+				Email: "alice@example.com",
+			},
+		},
+		{
+			name:     "Wrong password",
+			login:    "alice@example.com",
+			password: "bob-pw",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Unknown login",
+			login:    "dave@example.com",
+			password: "alice-pw",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Empty password",
+			login:    "alice@example.com",
+			password: "",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Filter injection",
+			login:    "*",
+			password: "alice-pw",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Ambiguous login",
+			login:    "shared@example.com",
+			password: "carol-pw",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Group member",
+			groupDN:  groupDN,
+			login:    "alice@example.com",
+			password: "alice-pw",
+			wantEntry: &Entry{
+				DN:    "uid=alice,ou=people,dc=example,dc=com",
+				Name:  "Alice Jones",
+				Email: "alice@example.com",
+			},
+		},
+		{
+			name:     "Not a group member",
+			groupDN:  groupDN,
+			login:    "bob@example.com",
+			password: "bob-pw",
+			wantErr:  ErrNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Directory{
+				URL:          s.URL,
+				BindDN:       serviceDN,
+				BindPassword: "service",
+				BaseDN:       "ou=people,dc=example,dc=com",
+				Filter:       "(&(objectClass=person)(mail=%s))",
+				NameAttr:     "cn",
+				EmailAttr:    "mail",
+				GroupDN:      tt.groupDN,
+			}
+
+			entry, err := d.Authenticate(context.Background(), tt.login, tt.password)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+			if tt.wantEntry == nil {
+				assert.Equal(t, entry == nil, true)
+				return
+			}
+			if entry == nil {
+				t.Fatalf("got no entry; err: %v", err)
+			}
+			assert.Equal(t, *entry, *tt.wantEntry)
+		})
+	}
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.RequireTLS = true
+
+	d := &Directory{
+		URL:          s.URL,
+		TLSConfig:    s.TLSConfig,
+		BindDN:       serviceDN,
+		BindPassword: "service",
+		BaseDN:       "dc=example,dc=com",
+		Filter:       "(uid=%s)",
+		NameAttr:     "cn",
+		EmailAttr:    "mail",
+	}
+
+	// Without StartTLS, the server refuses to bind.
+	_, err := d.Authenticate(context.Background(), "alice", "alice-pw")
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, errors.Is(err, ErrInvalidCredentials), false)
+
+	d.StartTLS = true
+	entry, err := d.Authenticate(context.Background(), "alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, entry.Email, "alice@example.com")
+
+	// The server's certificate is checked.
+	d.TLSConfig = nil
+	_, err = d.Authenticate(context.Background(), "alice", "alice-pw")
+	assert.Equal(t, err != nil, true)
+}
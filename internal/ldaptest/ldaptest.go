@@ -0,0 +1,365 @@
+// Package ldaptest provides an in-process LDAP server for tests. It
+// understands just enough of the protocol for package directory: simple
+// binds, StartTLS, and subtree searches with and, or, not, equality and
+// presence filters.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP protocol operations and result codes used by the server.
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+
+	resultSuccess                 = 0
+	resultProtocolError           = 2
+	resultSizeLimitExceeded       = 4
+	resultConfidentialityRequired = 13
+	resultInvalidCredentials      = 49
+	resultInsufficientAccess      = 50
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// Define an Entry type for a directory entry. Entries with a password can
+// be bound as.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+func (e *Entry) values(attr string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Define a Server type for a running LDAP server listening on a local port.
+type Server struct {
+	// URL is the server's address, e.g. ldap://127.0.0.1:38389.
+	URL string
+	// TLSConfig is a client configuration trusting the server's
+	// certificate, for use with StartTLS.
+	TLSConfig *tls.Config
+	// If RequireTLS is set, binds and searches are refused until the
+	// connection has been upgraded with StartTLS.
+	RequireTLS bool
+
+	listener  net.Listener
+	serverTLS *tls.Config
+	entries   []*Entry
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// NewServer starts a server holding the given entries. Callers should call
+// Close when they are done with it.
+func NewServer(entries ...*Entry) (*Server, error) {
+	serverTLS, clientTLS, err := newCertificate()
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		URL:       "ldap://" + l.Addr().String(),
+		TLSConfig: clientTLS,
+		listener:  l,
+		serverTLS: serverTLS,
+		entries:   entries,
+		conns:     make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// The handle method answers the requests on one connection until the client
+// unbinds or disconnects.
+func (s *Server) handle(conn net.Conn) {
+	var bound *Entry
+	secure := false
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case opBindRequest:
+			if s.RequireTLS && !secure {
+				write(conn, result(id, opBindResponse, resultConfidentialityRequired))
+				continue
+			}
+			dn, password := str(op.Children[1]), str(op.Children[2])
+			bound = nil
+			code := int64(resultInvalidCredentials)
+			if password == "" {
+				// An anonymous bind, which real servers accept too.
+				code = resultSuccess
+			} else if e := s.find(dn); e != nil && e.Password != "" && e.Password == password {
+				bound, code = e, resultSuccess
+			}
+			write(conn, result(id, opBindResponse, code))
+
+		case opSearchRequest:
+			if s.RequireTLS && !secure {
+				write(conn, result(id, opSearchDone, resultConfidentialityRequired))
+				continue
+			}
+			if bound == nil {
+				write(conn, result(id, opSearchDone, resultInsufficientAccess))
+				continue
+			}
+			s.search(conn, id, op)
+
+		case opExtendedRequest:
+			if secure || str(op.Children[0]) != startTLSOID {
+				write(conn, result(id, opExtendedResponse, resultProtocolError))
+				continue
+			}
+			write(conn, result(id, opExtendedResponse, resultSuccess))
+			tlsConn := tls.Server(conn, s.serverTLS)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+
+		default:
+			return
+		}
+	}
+}
+
+// The search method sends the entries below the base DN which match the
+// filter, with the requested attributes.
+func (s *Server) search(conn net.Conn, id int64, op *ber.Packet) {
+	base := str(op.Children[0])
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attrs []string
+	for _, attr := range op.Children[7].Children {
+		attrs = append(attrs, str(attr))
+	}
+
+	sent := 0
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), strings.ToLower(base)) || !matches(e, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(sent) == sizeLimit {
+			write(conn, result(id, opSearchDone, resultSizeLimitExceeded))
+			return
+		}
+		write(conn, entryPacket(id, e, attrs))
+		sent++
+	}
+	write(conn, result(id, opSearchDone, resultSuccess))
+}
+
+func (s *Server) find(dn string) *Entry {
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			return e
+		}
+	}
+	return nil
+}
+
+// The matches function evaluates a search filter against an entry.
+func matches(e *Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case 0: // and
+		for _, child := range filter.Children {
+			if !matches(e, child) {
+				return false
+			}
+		}
+		return true
+	case 1: // or
+		for _, child := range filter.Children {
+			if matches(e, child) {
+				return true
+			}
+		}
+		return false
+	case 2: // not
+		return len(filter.Children) == 1 && !matches(e, filter.Children[0])
+	case 3: // equality
+		want := str(filter.Children[1])
+		for _, v := range e.values(str(filter.Children[0])) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case 7: // present
+		attr := filter.Data.String()
+		return strings.EqualFold(attr, "objectClass") || len(e.values(attr)) > 0
+	default:
+		return false
+	}
+}
+
+func str(p *ber.Packet) string {
+	if s, ok := p.Value.(string); ok {
+		return s
+	}
+	return p.Data.String()
+}
+
+func envelope(id int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	return p
+}
+
+func result(id int64, tag ber.Tag, code int64) *ber.Packet {
+	p := envelope(id)
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	p.AppendChild(op)
+	return p
+}
+
+func entryPacket(id int64, e *Entry, attrs []string) *ber.Packet {
+	p := envelope(id)
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		if len(attrs) > 0 && !containsFold(attrs, name) {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		list.AppendChild(attr)
+	}
+	op.AppendChild(list)
+	p.AppendChild(op)
+	return p
+}
+
+func write(conn net.Conn, p *ber.Packet) {
+	conn.Write(p.Bytes())
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// The newCertificate function creates a self-signed certificate for
+// 127.0.0.1, and returns a server configuration using it and a client
+// configuration trusting it.
+func newCertificate() (*tls.Config, *tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverTLS := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+	}
+	return serverTLS, &tls.Config{RootCAs: pool}, nil
+}
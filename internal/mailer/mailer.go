@@ -0,0 +1,169 @@
+// Package mailer sends the emails written by snippetbox, such as password
+// reset links. Messages are plain text.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Define a Message type to hold a single plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// The Mailer interface is implemented by everything that can deliver a
+// message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// bytes formats the message as an RFC 5322 email from the given sender.
+// Header values may not contain line breaks, so that a recipient or subject
+// can't add headers of its own.
+func (msg Message) bytes(from string, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("mailer: header contains a line break")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// SMTP sends messages through an SMTP server. STARTTLS is used whenever the
+// server offers it, and the connection is authenticated if a username is
+// set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	Sender   string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.bytes(m.Sender, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(m.Sender)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Writer writes every message to an io.Writer, such as a file, instead of
+// sending it. It is meant for development and tests.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	sender string
+}
+
+// NewWriter returns a Writer which writes messages from sender to w,
+// separated by blank lines.
+func NewWriter(w io.Writer, sender string) *Writer {
+	return &Writer{w: w, sender: sender}
+}
+
+func (m *Writer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.bytes(m.sender, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.w.Write(append(data, "\r\n\r\n"...))
+	return err
+}
+
+// Log writes every message to a structured logger instead of sending it. It
+// is meant for development. Logs are often kept and shipped elsewhere, so
+// links in the body, which may hold secret tokens, are redacted; use Writer
+// to see whole messages.
+type Log struct {
+	Logger *slog.Logger
+}
+
+var linkRX = regexp.MustCompile(`https?://\S+`)
+
+func (m *Log) Send(ctx context.Context, msg Message) error {
+	body := linkRX.ReplaceAllString(msg.Body, "[link redacted]")
+	m.Logger.InfoContext(ctx, "email", "to", msg.To, "subject", msg.Subject, "body", body)
+	return nil
+}
@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{To: "alice@example.com", Subject: "Réinitialiser", Body: "Hello\nWorld\n"}
+	date := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	data, err := msg.bytes("Snippetbox <no-reply@example.com>", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "From: Snippetbox <no-reply@example.com>\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n" +
+		"Date: Sun, 17 Mar 2024 10:15:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Hello\r\nWorld\r\n"
+	assert.Equal(t, string(data), want)
+
+	msg.To = "alice@example.com\r\nBcc: eve@example.com"
+	_, err = msg.bytes("no-reply@example.com", date)
+	if err == nil {
+		t.Errorf("got no error for a header with a line break")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriter(&buf, "no-reply@example.com")
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.StringContains(t, buf.String(), "To: alice@example.com\r\n")
+	assert.StringContains(t, buf.String(), "\r\n\r\nHello")
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Follow this link:\n\nhttps://snippetbox.test/user/reset-password?token=secret\n\nThanks",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.StringContains(t, buf.String(), "to=alice@example.com")
+	assert.StringContains(t, buf.String(), `Follow this link:\n\n[link redacted]\n\nThanks`)
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("log contains the token: %q", buf.String())
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The fake server answers every command with success and records the
+	// envelope and the message data.
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var log strings.Builder
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				log.WriteString(strings.TrimSpace(line) + "\n")
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					log.WriteString(line)
+				}
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				received <- log.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	m := &SMTP{Addr: ln.Addr().String(), Sender: "no-reply@example.com"}
+	err = m.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		assert.StringContains(t, got, "MAIL FROM:<no-reply@example.com>")
+		assert.StringContains(t, got, "RCPT TO:<alice@example.com>")
+		assert.StringContains(t, got, "Subject: Hi\r\n")
+		assert.StringContains(t, got, "\r\n\r\nHello")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SMTP server received no message")
+	}
+}
@@ -0,0 +1,65 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+type CommentModel struct{}
+
+// The mock comments are rebuilt on each call, as ListForSnippet fills in
+// their replies.
+func mockComments() []*models.Comment {
+	return []*models.Comment{
+		{
+			ID:        1,
+			SnippetID: 1,
+			UserID:    2,
+			UserName:  "Other",
+			File:      "main.go",
+			Line:      1,
+			Body:      "Looks **good**",
+			Created:   time.Now(),
+		},
+		{
+			ID:        2,
+			SnippetID: 1,
+			UserID:    1,
+			UserName:  "Test",
+			ParentID:  1,
+			Body:      "Thanks!",
+			Created:   time.Now(),
+		},
+	}
+}
+
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID int, file string, line int, body string) (int, error) {
+	return 3, nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id int) (*models.Comment, error) {
+	for _, c := range mockComments() {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *CommentModel) ListForSnippet(ctx context.Context, snippetID int, newestFirst bool) ([]*models.Comment, error) {
+	if snippetID != 1 {
+		return []*models.Comment{}, nil
+	}
+	return models.Thread(mockComments(), newestFirst), nil
+}
+
+func (m *CommentModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1, 2:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
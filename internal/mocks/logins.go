@@ -0,0 +1,73 @@
+package mocks
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The mock LoginAttemptModel keeps its data in memory, so that tests can
+// run into the login throttle.
+type LoginAttemptModel struct {
+	mu       sync.Mutex
+	attempts []*models.LoginAttempt
+}
+
+func (m *LoginAttemptModel) Start(ctx context.Context, email, ip string, window time.Duration) (int, *models.LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	f := &models.LoginFailures{}
+	for _, a := range m.attempts {
+		if (a.Result != models.LoginFailed && a.Result != models.LoginPending) || time.Since(a.Created) >= window {
+			continue
+		}
+		if a.Email == email && !a.Cleared {
+			f.Account++
+			f.AccountLast = a.Created
+		}
+		if a.IP == ip {
+			f.IP++
+			f.IPLast = a.Created
+		}
+	}
+	id := len(m.attempts) + 1
+	m.attempts = append(m.attempts, &models.LoginAttempt{
+		ID:      id,
+		Email:   email,
+		IP:      ip,
+		Result:  models.LoginPending,
+		Created: time.Now(),
+	})
+	return id, f, nil
+}
+
+func (m *LoginAttemptModel) Finish(ctx context.Context, id int, result string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempts[id-1]
+	attempt.Result = result
+	for _, a := range m.attempts {
+		if result == models.LoginSucceeded && a.Email == attempt.Email && a.Result == models.LoginFailed {
+			a.Cleared = true
+		}
+	}
+	return nil
+}
+
+// Results returns the results of the recorded attempts, oldest first.
+func (m *LoginAttemptModel) Results() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []string{}
+	for _, a := range m.attempts {
+		results = append(results, a.Result)
+	}
+	return results
+}
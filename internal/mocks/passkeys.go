@@ -0,0 +1,80 @@
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The mock PasskeyModel keeps its data in memory, so that tests can register
+// a passkey and then log in with it.
+type PasskeyModel struct {
+	mu       sync.Mutex
+	passkeys []*models.Passkey
+	nextID   int
+}
+
+func (m *PasskeyModel) Insert(ctx context.Context, userID int, name string, credentialID, credential []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return models.ErrDuplicateCredential
+		}
+	}
+	m.nextID++
+	m.passkeys = append(m.passkeys, &models.Passkey{
+		ID:           m.nextID,
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credentialID,
+		Credential:   credential,
+		Created:      time.Now(),
+	})
+	return nil
+}
+
+func (m *PasskeyModel) ListForUser(ctx context.Context, userID int) ([]*models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	passkeys := []*models.Passkey{}
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			c := *p
+			passkeys = append(passkeys, &c)
+		}
+	}
+	return passkeys, nil
+}
+
+func (m *PasskeyModel) Update(ctx context.Context, id int, credential []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.passkeys {
+		if p.ID == id {
+			p.Credential = credential
+			p.LastUsed = time.Now()
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *PasskeyModel) Delete(ctx context.Context, id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.passkeys {
+		if p.ID == id && p.UserID == userID {
+			m.passkeys = append(m.passkeys[:i], m.passkeys[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
@@ -0,0 +1,119 @@
+package mocks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+type rememberToken struct {
+	token     string
+	userID    int
+	sessionID int
+	usedAt    time.Time
+	expiry    time.Time
+}
+
+// The mock RememberTokenModel keeps its data in memory, so that tests can
+// use, rotate and replay tokens.
+type RememberTokenModel struct {
+	mu     sync.Mutex
+	tokens []*rememberToken
+}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, sessionID int, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:8]) + "." + hex.EncodeToString(b[8:])
+	m.tokens = append(m.tokens, &rememberToken{
+		token:     token,
+		userID:    userID,
+		sessionID: sessionID,
+		expiry:    time.Now().Add(ttl),
+	})
+	return token, nil
+}
+
+func (m *RememberTokenModel) Consume(ctx context.Context, token string, grace time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.token == token && time.Now().Before(t.expiry) {
+			if !t.usedAt.IsZero() {
+				if time.Since(t.usedAt) < grace {
+					return 0, models.ErrTokenRecentlyUsed
+				}
+				return t.userID, models.ErrTokenReplayed
+			}
+			t.usedAt = time.Now()
+			return t.userID, nil
+		}
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, token string) error {
+	m.delete(func(t *rememberToken) bool { return t.token == token })
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, sessionID int) error {
+	m.delete(func(t *rememberToken) bool { return t.sessionID == sessionID })
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteForUser(ctx context.Context, userID, exceptSessionID int) error {
+	m.delete(func(t *rememberToken) bool { return t.userID == userID && t.sessionID != exceptSessionID })
+	return nil
+}
+
+func (m *RememberTokenModel) delete(match func(*rememberToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.tokens[:0]
+	for _, t := range m.tokens {
+		if !match(t) {
+			kept = append(kept, t)
+		}
+	}
+	m.tokens = kept
+}
+
+// Age makes every used token look as if it was used d earlier, so that
+// tests don't have to wait for the grace period to pass.
+func (m *RememberTokenModel) Age(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if !t.usedAt.IsZero() {
+			t.usedAt = t.usedAt.Add(-d)
+		}
+	}
+}
+
+// Count returns how many tokens are stored for the user, used or not.
+func (m *RememberTokenModel) Count(userID int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.tokens {
+		if t.userID == userID {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) Insert(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	return "reset-token", nil
+}
+
+func (m *PasswordResetModel) UserID(ctx context.Context, plaintext string) (int, error) {
+	if plaintext == "reset-token" {
+		return 1, nil
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *PasswordResetModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	return m.UserID(ctx, plaintext)
+}
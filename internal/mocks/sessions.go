@@ -0,0 +1,106 @@
+package mocks
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The mock UserSessionModel keeps its data in memory, so that tests can
+// list and log out the sessions of several browsers.
+type UserSessionModel struct {
+	mu       sync.Mutex
+	sessions []*models.UserSession
+	nextID   int
+}
+
+func (m *UserSessionModel) Insert(ctx context.Context, userID int, ip, userAgent string, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	now := time.Now()
+	m.sessions = append(m.sessions, &models.UserSession{
+		ID:        m.nextID,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   now,
+		LastSeen:  now,
+		Expiry:    now.Add(ttl),
+	})
+	return m.nextID, nil
+}
+
+func (m *UserSessionModel) Get(ctx context.Context, id int) (*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.ID == id && time.Now().Before(s.Expiry) {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, id int, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.ID == id {
+			s.LastSeen = time.Now()
+			s.IP = ip
+		}
+	}
+	return nil
+}
+
+func (m *UserSessionModel) ListForUser(ctx context.Context, userID int) ([]*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*models.UserSession{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && time.Now().Before(s.Expiry) {
+			c := *s
+			sessions = append(sessions, &c)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (m *UserSessionModel) Delete(ctx context.Context, id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.sessions {
+		if s.ID == id && s.UserID == userID {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserSessionModel) DeleteForUser(ctx context.Context, userID, exceptID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.sessions[:0]
+	for _, s := range m.sessions {
+		if s.UserID != userID || s.ID == exceptID {
+			kept = append(kept, s)
+		}
+	}
+	m.sessions = kept
+	return nil
+}
@@ -1,43 +1,186 @@
 package mocks
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com.scottyfionnghall.snippetbox/internal/models"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "Test title",
-	Content: "Test content...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:     1,
+	UserID: 1,
+	Title:  "Test title",
+	Stars:  1,
+	Files: []*models.SnippetFile{
+		{Name: "main.go", Language: "go", Content: "Test content..."},
+		{Name: "README.md", Language: "markdown", Content: "Second file\nline two\nline three\n"},
+	},
+	Excerpt:    "Test content...",
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Updated:    time.Now(),
+	Expires:    time.Now(),
 }
 
-type SnippetModel struct{}
+var mockPrivateSnippet = &models.Snippet{
+	ID:     3,
+	UserID: 1,
+	Title:  "Private title",
+	Files: []*models.SnippetFile{
+		{Name: "secret.txt", Language: "plaintext", Content: "Private content..."},
+	},
+	Visibility: models.VisibilityPrivate,
+	Created:    time.Now(),
+	Updated:    time.Now(),
+	Expires:    time.Now(),
+}
+
+var mockForkSnippet = &models.Snippet{
+	ID:       4,
+	UserID:   2,
+	ParentID: 1,
+	Title:    "Forked title",
+	Files: []*models.SnippetFile{
+		{Name: "main.go", Language: "go", Content: "Forked content..."},
+	},
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Updated:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockExpiringSnippet can still be read, but expires before it can be
+// updated.
+var mockExpiringSnippet = &models.Snippet{
+	ID:     5,
+	UserID: 1,
+	Title:  "Expiring title",
+	Files: []*models.SnippetFile{
+		{Name: "main.go", Language: "go", Content: "Expiring content..."},
+	},
+	Visibility: models.VisibilityPublic,
+	Created:    time.Now(),
+	Updated:    time.Now(),
+	Expires:    time.Now(),
+}
 
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
+// The mock SnippetModel remembers the snippet inserted last, with ID 2, so
+// that handlers can read it back. Until then there is no snippet 2.
+type SnippetModel struct {
+	mu       sync.Mutex
+	inserted *models.Snippet
+}
+
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, files []*models.SnippetFile, expires int, visibility string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.inserted = &models.Snippet{
+		ID:         2,
+		UserID:     userID,
+		Title:      title,
+		Files:      files,
+		Visibility: visibility,
+		Created:    now,
+		Updated:    now,
+		Expires:    now.AddDate(0, 0, expires),
+	}
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 3:
+		return mockPrivateSnippet, nil
+	case 4:
+		return mockForkSnippet, nil
+	case 5:
+		return mockExpiringSnippet, nil
+	case 2:
+		if m.inserted != nil {
+			return m.inserted, nil
+		}
+		return nil, models.ErrNoRecord
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+func (m *SnippetModel) Latest(ctx context.Context) ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
 
-func (m *SnippetModel) Delete(id int) error {
+func (m *SnippetModel) List(ctx context.Context, userID, limit, offset int) ([]*models.Snippet, int, error) {
+	snippets := []*models.Snippet{mockSnippet}
+	if userID == 1 {
+		snippets = []*models.Snippet{mockPrivateSnippet, mockSnippet}
+	} else if userID != 0 {
+		snippets = []*models.Snippet{}
+	}
+	total := len(snippets)
+	if offset >= total {
+		return []*models.Snippet{}, total, nil
+	}
+	return snippets[offset:min(offset+limit, total)], total, nil
+}
+
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, files []*models.SnippetFile, expires int, visibility string) error {
 	switch id {
-	case 1:
+	case 1, 3:
 		return nil
 	default:
 		return models.ErrNoRecord
 	}
 }
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1, 3:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Fork(ctx context.Context, id, userID int) (int, error) {
+	switch id {
+	case 1, 3, 4:
+		return 4, nil
+	default:
+		return 0, models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Forks(ctx context.Context, id, userID int) ([]*models.Snippet, error) {
+	if id == 1 {
+		return []*models.Snippet{mockForkSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Public(ctx context.Context, userID, limit, offset int) ([]*models.Snippet, int, error) {
+	var snippets []*models.Snippet
+	switch userID {
+	case 0:
+		snippets = []*models.Snippet{mockForkSnippet, mockSnippet}
+	case 1:
+		snippets = []*models.Snippet{mockSnippet}
+	case 2:
+		snippets = []*models.Snippet{mockForkSnippet}
+	default:
+		snippets = []*models.Snippet{}
+	}
+	total := len(snippets)
+	if offset >= total {
+		return []*models.Snippet{}, total, nil
+	}
+	return snippets[offset:min(offset+limit, total)], total, nil
+}
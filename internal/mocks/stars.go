@@ -0,0 +1,33 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+type StarModel struct{}
+
+func (m *StarModel) Star(ctx context.Context, userID, snippetID int) error {
+	return nil
+}
+
+func (m *StarModel) Unstar(ctx context.Context, userID, snippetID int) error {
+	return nil
+}
+
+func (m *StarModel) IsStarred(ctx context.Context, userID, snippetID int) (bool, error) {
+	return userID == 1 && snippetID == 1, nil
+}
+
+func (m *StarModel) List(ctx context.Context, userID, limit, offset int) ([]*models.Snippet, int, error) {
+	snippets := []*models.Snippet{}
+	if userID == 1 {
+		snippets = []*models.Snippet{mockSnippet}
+	}
+	total := len(snippets)
+	if offset >= total {
+		return []*models.Snippet{}, total, nil
+	}
+	return snippets[offset:min(offset+limit, total)], total, nil
+}
@@ -0,0 +1,59 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+var mockReadToken = &models.APIToken{
+	ID:      1,
+	UserID:  1,
+	Name:    "Read only",
+	Scopes:  []string{models.ScopeRead},
+	Created: time.Now(),
+}
+
+var mockFullToken = &models.APIToken{
+	ID:       2,
+	UserID:   1,
+	Name:     "Everything",
+	Scopes:   []string{models.ScopeRead, models.ScopeWrite, models.ScopeDelete},
+	Created:  time.Now(),
+	LastUsed: time.Now(),
+}
+
+type TokenModel struct{}
+
+func (m *TokenModel) Insert(ctx context.Context, userID int, name string, scopes []string) (string, error) {
+	return "sbx_newtoken", nil
+}
+
+func (m *TokenModel) GetByToken(ctx context.Context, plaintext string) (*models.APIToken, error) {
+	switch plaintext {
+	case "sbx_read":
+		return mockReadToken, nil
+	case "sbx_full":
+		return mockFullToken, nil
+	case "sbx_disabled":
+		// The token of a disabled user, which the query skips.
+		return nil, models.ErrNoRecord
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *TokenModel) ListForUser(ctx context.Context, userID int) ([]*models.APIToken, error) {
+	if userID == 1 {
+		return []*models.APIToken{mockFullToken, mockReadToken}, nil
+	}
+	return []*models.APIToken{}, nil
+}
+
+func (m *TokenModel) Delete(ctx context.Context, id, userID int) error {
+	if userID == 1 && (id == 1 || id == 2) {
+		return nil
+	}
+	return models.ErrNoRecord
+}
@@ -0,0 +1,97 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
+
+// The mock TwoFactorModel keeps its data in memory, so that tests can enrol
+// a user and then log in with a code.
+type TwoFactorModel struct {
+	mu       sync.Mutex
+	settings map[int]*models.TwoFactor
+	codes    map[int][]string
+}
+
+func (m *TwoFactorModel) Get(ctx context.Context, userID int) (*models.TwoFactor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tf, ok := m.settings[userID]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	c := *tf
+	return &c, nil
+}
+
+func (m *TwoFactorModel) SetSecret(ctx context.Context, userID int, secret []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.settings == nil {
+		m.settings = make(map[int]*models.TwoFactor)
+	}
+	m.settings[userID] = &models.TwoFactor{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, step int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tf, ok := m.settings[userID]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	tf.Enabled = true
+	tf.LastStep = step
+	if m.codes == nil {
+		m.codes = make(map[int][]string)
+	}
+	m.codes[userID] = []string{"aaaa-bbbb", "cccc-dddd"}
+	return append([]string{}, m.codes[userID]...), nil
+}
+
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.settings, userID)
+	delete(m.codes, userID)
+	return nil
+}
+
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tf, ok := m.settings[userID]
+	if !ok || !tf.Enabled || tf.LastStep >= step {
+		return false, nil
+	}
+	tf.LastStep = step
+	return true, nil
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.codes[userID] {
+		if c == code {
+			m.codes[userID] = append(m.codes[userID][:i], m.codes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.codes[userID]), nil
+}
@@ -1,10 +1,15 @@
 package mocks
 
-import "github.com.scottyfionnghall.snippetbox/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/models"
+)
 
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) error {
 	switch email {
 	case "dupe@example.com":
 		return models.ErrDuplicateEmail
@@ -13,19 +18,103 @@ func (m *UserModel) Insert(name, email, password string) error {
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	if email == "test@example.com" && password == "pa$$word" {
 		return 1, nil
 	}
+	if email == "other@example.com" && password == "pa$$word" {
+		return 2, nil
+	}
 
 	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2, 3:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
+
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
+	switch id {
+	case 1:
+		return &models.User{ID: 1, Name: "Test", Email: "test@example.com", Created: time.Now(),
+			Verified: true, Bio: "Writes **Go**", HasAvatar: true}, nil
+	case 2:
+		return &models.User{ID: 2, Name: "Other", Email: "other@example.com", Created: time.Now()}, nil
+	case 3:
+		// The user created by InsertExternal.
+		return &models.User{ID: 3, Name: "New", Email: "new@example.com", Created: time.Now(), Verified: true}, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	switch email {
+	case "test@example.com":
+		return m.Get(ctx, 1)
+	case "other@example.com":
+		return m.Get(ctx, 2)
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdateBio(ctx context.Context, id int, bio string) error {
+	return nil
+}
+
+func (m *UserModel) SetAvatar(ctx context.Context, id int, image []byte, contentType string) error {
+	return nil
+}
+
+// The mock avatar is a 1x1 GIF.
+var mockAvatar = []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+func (m *UserModel) Avatar(ctx context.Context, id int) ([]byte, string, error) {
+	if id == 1 {
+		return mockAvatar, "image/gif", nil
+	}
+	return nil, "", models.ErrNoRecord
+}
+
+func (m *UserModel) CheckPassword(ctx context.Context, id int, password string) error {
+	if (id == 1 || id == 2) && password == "pa$$word" {
+		return nil
+	}
+	return models.ErrInvalidCredentials
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) UpdateEmail(ctx context.Context, id int, email string) error {
+	switch email {
+	case "dupe@example.com", "other@example.com":
+		return models.ErrDuplicateEmail
+	default:
+		return nil
+	}
+}
+
+func (m *UserModel) SetPassword(ctx context.Context, id int, password string) error {
+	return nil
+}
+
+func (m *UserModel) SetVerified(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *UserModel) InsertExternal(ctx context.Context, name, email string) (int, error) {
+	switch email {
+	case "dupe@example.com":
+		return 0, models.ErrDuplicateEmail
+	default:
+		return 3, nil
+	}
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Define a SnippetFilter type to select snippets for admin tooling. Zero
+// values don't filter, so an empty filter matches every snippet.
+type SnippetFilter struct {
+	UserID        int
+	CreatedBefore time.Time
+	ExpiredOnly   bool
+}
+
+// where returns the WHERE clause and arguments for the filter.
+func (f SnippetFilter) where() (string, []any) {
+	conditions := []string{"true"}
+	args := []any{}
+	if f.UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if !f.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created < ?")
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if f.ExpiredOnly {
+		conditions = append(conditions, "expires <= UTC_TIMESTAMP()")
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Method to return every snippet matching the filter, including expired and
+// private ones, oldest first.
+func (m *SnippetModel) Find(ctx context.Context, f SnippetFilter) (snippets []*Snippet, err error) {
+	where, args := f.where()
+	query := `SELECT id, COALESCE(user_id,0), title, visibility, created, expires
+	FROM snippets ` + where + ` ORDER BY id`
+
+	ctx, span := startSpan(ctx, "SnippetModel.Find", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets = []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// Method to delete every snippet matching the filter. It returns the number
+// of snippets deleted.
+func (m *SnippetModel) Purge(ctx context.Context, f SnippetFilter) (n int64, err error) {
+	where, args := f.where()
+	query := "DELETE FROM snippets " + where
+
+	ctx, span := startSpan(ctx, "SnippetModel.Purge", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Define a Stats type to hold instance-wide counts for admins.
+type Stats struct {
+	Users           int
+	DisabledUsers   int
+	Snippets        int
+	ExpiredSnippets int
+	Sessions        int
+	APITokens       int
+}
+
+// GetStats counts the rows of interest across the database.
+func GetStats(ctx context.Context, db *sql.DB) (*Stats, error) {
+	s := &Stats{}
+	queries := []struct {
+		query string
+		dst   *int
+	}{
+		{"SELECT COUNT(*) FROM users", &s.Users},
+		{"SELECT COUNT(*) FROM users WHERE disabled", &s.DisabledUsers},
+		{"SELECT COUNT(*) FROM snippets WHERE expires > UTC_TIMESTAMP()", &s.Snippets},
+		{"SELECT COUNT(*) FROM snippets WHERE expires <= UTC_TIMESTAMP()", &s.ExpiredSnippets},
+		{"SELECT COUNT(*) FROM sessions WHERE expiry > UTC_TIMESTAMP(6)", &s.Sessions},
+		{"SELECT COUNT(*) FROM api_tokens", &s.APITokens},
+	}
+	for _, q := range queries {
+		spanCtx, span := startSpan(ctx, "GetStats", q.query)
+		err := db.QueryRowContext(spanCtx, q.query).Scan(q.dst)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
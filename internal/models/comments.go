@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Define a Comment type to hold the data for a comment on a snippet. A
+// comment bound to a line has both File and Line set. Replies are only
+// filled in by ListForSnippet.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	ParentID  int
+	File      string
+	Line      int
+	Body      string
+	Created   time.Time
+	Replies   []*Comment
+}
+
+// Define a CommentModel type wich wraps a sql.DB connection pool.
+type CommentModel struct {
+	DB         *sql.DB
+	InsertStmt *sql.Stmt
+	GetStmt    *sql.Stmt
+	ListStmt   *sql.Stmt
+	DeleteStmt *sql.Stmt
+}
+
+type CommentModelInterface interface {
+	Insert(ctx context.Context, snippetID, userID, parentID int, file string, line int, body string) (int, error)
+	Get(ctx context.Context, id int) (*Comment, error)
+	ListForSnippet(ctx context.Context, snippetID int, newestFirst bool) ([]*Comment, error)
+	Delete(ctx context.Context, id int) error
+}
+
+// SQL statements used by the CommentModel. Deleting a comment also deletes
+// its replies through the parent_id foreign key.
+const (
+	commentInsertQuery = `INSERT INTO comments (snippet_id, user_id, parent_id, file_name, line, body, created)
+	VALUES(?,?,NULLIF(?,0),NULLIF(?,''),NULLIF(?,0),?,UTC_TIMESTAMP())`
+	commentGetQuery = `SELECT c.id, c.snippet_id, c.user_id, u.name, COALESCE(c.parent_id,0),
+	COALESCE(c.file_name,''), COALESCE(c.line,0), c.body, c.created
+	FROM comments c JOIN users u ON u.id = c.user_id WHERE c.id = ?`
+	commentListQuery = `SELECT c.id, c.snippet_id, c.user_id, u.name, COALESCE(c.parent_id,0),
+	COALESCE(c.file_name,''), COALESCE(c.line,0), c.body, c.created
+	FROM comments c JOIN users u ON u.id = c.user_id WHERE c.snippet_id = ?
+	ORDER BY c.created, c.id`
+	commentDeleteQuery = `DELETE FROM comments WHERE id = ?`
+)
+
+// Creates a constructor for a CommentModel, which includes prepared statements.
+func NewCommentModel(db *sql.DB) (*CommentModel, error) {
+	insertStmt, err := db.Prepare(commentInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	getStmt, err := db.Prepare(commentGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	listStmt, err := db.Prepare(commentListQuery)
+	if err != nil {
+		return nil, err
+	}
+	deleteStmt, err := db.Prepare(commentDeleteQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &CommentModel{
+		DB:         db,
+		InsertStmt: insertStmt,
+		GetStmt:    getStmt,
+		ListStmt:   listStmt,
+		DeleteStmt: deleteStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *CommentModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.InsertStmt, m.GetStmt, m.ListStmt, m.DeleteStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to add a comment to a snippet. A parentID of 0 starts a new thread,
+// and an empty file with a line of 0 means the comment isn't bound to a line.
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID int, file string, line int, body string) (id int, err error) {
+	ctx, span := startSpan(ctx, "CommentModel.Insert", commentInsertQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.InsertStmt.ExecContext(ctx, snippetID, userID, parentID, file, line, body)
+	if err != nil {
+		return 0, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(lastID), nil
+}
+
+// Method to return a single comment. If there is no such comment,
+// ErrNoRecord is returned.
+func (m *CommentModel) Get(ctx context.Context, id int) (c *Comment, err error) {
+	ctx, span := startSpan(ctx, "CommentModel.Get", commentGetQuery)
+	defer func() { endSpan(span, err) }()
+
+	c = &Comment{}
+	err = m.GetStmt.QueryRowContext(ctx, id).Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName,
+		&c.ParentID, &c.File, &c.Line, &c.Body, &c.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Method to return the comments on a snippet as threads. The top-level
+// comments are ordered by creation date, newest first if newestFirst is
+// true, while replies are always oldest first so that they read in order.
+func (m *CommentModel) ListForSnippet(ctx context.Context, snippetID int, newestFirst bool) (threads []*Comment, err error) {
+	ctx, span := startSpan(ctx, "CommentModel.ListForSnippet", commentListQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.ListStmt.QueryContext(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		c := &Comment{}
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName,
+			&c.ParentID, &c.File, &c.Line, &c.Body, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return Thread(comments, newestFirst), nil
+}
+
+// Thread arranges a flat list of comments, ordered oldest first, into
+// threads by attaching each reply to its parent. Replies whose parent isn't
+// in the list are treated as top-level comments.
+func Thread(comments []*Comment, newestFirst bool) []*Comment {
+	byID := make(map[int]*Comment, len(comments))
+	for _, c := range comments {
+		byID[c.ID] = c
+	}
+
+	threads := []*Comment{}
+	for _, c := range comments {
+		if parent, ok := byID[c.ParentID]; ok && c.ParentID != 0 {
+			parent.Replies = append(parent.Replies, c)
+			continue
+		}
+		threads = append(threads, c)
+	}
+	if newestFirst {
+		slices.Reverse(threads)
+	}
+	return threads
+}
+
+// Method to delete a comment along with all of its replies.
+func (m *CommentModel) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "CommentModel.Delete", commentDeleteQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DeleteStmt.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
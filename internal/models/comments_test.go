@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestThread(t *testing.T) {
+	comments := []*Comment{
+		{ID: 1},
+		{ID: 2},
+		{ID: 3, ParentID: 1},
+		{ID: 4, ParentID: 3},
+		{ID: 5, ParentID: 1},
+		// The parent of this reply has been deleted.
+		{ID: 6, ParentID: 99},
+	}
+
+	threads := Thread(comments, true)
+
+	var ids []int
+	for _, c := range threads {
+		ids = append(ids, c.ID)
+	}
+	if len(ids) != 3 || ids[0] != 6 || ids[1] != 2 || ids[2] != 1 {
+		t.Fatalf("got top-level comments %v; want [6 2 1]", ids)
+	}
+
+	replies := threads[2].Replies
+	if len(replies) != 2 || replies[0].ID != 3 || replies[1].ID != 5 {
+		t.Errorf("got replies %v; want comments 3 and 5 in order", replies)
+	}
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].ID != 4 {
+		t.Errorf("got nested replies %v; want comment 4", replies[0].Replies)
+	}
+}
@@ -12,4 +12,12 @@ var (
 	// Error for when a user tries to signup with an email that is already
 	// in a database
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// Error for when a passkey is registered which is already stored
+	ErrDuplicateCredential = errors.New("models: duplicate credential")
+	// Error for when a remember me token which has already been used is
+	// presented again, which means it has probably been stolen
+	ErrTokenReplayed = errors.New("models: remember token replayed")
+	// Error for when a remember me token is presented again just after it
+	// was used, as by two requests which the browser sent at once
+	ErrTokenRecentlyUsed = errors.New("models: remember token recently used")
 )
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Results of a login attempt.
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failure"
+	// LoginBlocked attempts were refused because of earlier failures,
+	// without checking the password. They don't count as failures.
+	LoginBlocked = "blocked"
+	// LoginPassword attempts had the right password, but the user still
+	// has to enter a two-factor code. They neither count as failures nor
+	// clear them; wrong codes are recorded as failures.
+	LoginPassword = "password"
+	// LoginPending attempts have started but their password or code
+	// hasn't been checked yet. They count as failures, so that attempts
+	// made at the same time count towards each other's throttle.
+	LoginPending = "pending"
+	// LoginError attempts couldn't be checked because of an unexpected
+	// error, such as the directory being down. They don't count as
+	// failures.
+	LoginError = "error"
+)
+
+// Define a LoginAttempt type to hold the data for a password login attempt.
+type LoginAttempt struct {
+	ID      int
+	Email   string
+	IP      string
+	Result  string
+	Cleared bool
+	Created time.Time
+}
+
+// Define a LoginFailures type to hold the recent failed attempts for an
+// email address and a client IP address, with the time of the latest one.
+type LoginFailures struct {
+	Account     int
+	AccountLast time.Time
+	IP          int
+	IPLast      time.Time
+}
+
+// Define a LoginAttemptModel type wich wraps a sql.DB connection pool. It
+// records password login attempts for auditing and throttling.
+type LoginAttemptModel struct {
+	DB                *sql.DB
+	InsertStmt        *sql.Stmt
+	AccountFailedStmt *sql.Stmt
+	IPFailedStmt      *sql.Stmt
+}
+
+type LoginAttemptModelInterface interface {
+	Start(ctx context.Context, email, ip string, window time.Duration) (int, *LoginFailures, error)
+	Finish(ctx context.Context, id int, result string) error
+}
+
+// SQL statements used by the LoginAttemptModel.
+const (
+	loginInsertQuery = `INSERT INTO login_attempts (email, ip, result, created)
+	VALUES(?,?,'pending',UTC_TIMESTAMP())`
+	loginAccountFailedQuery = `SELECT COUNT(*), MAX(created) FROM login_attempts
+	WHERE email = ? AND result IN ('failure', 'pending') AND NOT cleared
+	AND created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND) AND id <> ?`
+	loginIPFailedQuery = `SELECT COUNT(*), MAX(created) FROM login_attempts
+	WHERE ip = ? AND result IN ('failure', 'pending')
+	AND created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND) AND id <> ?`
+	loginClearQuery = `UPDATE login_attempts SET cleared = TRUE
+	WHERE email = ? AND result = 'failure' AND NOT cleared`
+)
+
+// Creates a constructor for a LoginAttemptModel, which includes prepared
+// statements.
+func NewLoginAttemptModel(db *sql.DB) (*LoginAttemptModel, error) {
+	insertStmt, err := db.Prepare(loginInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	accountFailedStmt, err := db.Prepare(loginAccountFailedQuery)
+	if err != nil {
+		return nil, err
+	}
+	ipFailedStmt, err := db.Prepare(loginIPFailedQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginAttemptModel{
+		DB:                db,
+		InsertStmt:        insertStmt,
+		AccountFailedStmt: accountFailedStmt,
+		IPFailedStmt:      ipFailedStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *LoginAttemptModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.InsertStmt, m.AccountFailedStmt, m.IPFailedStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The normalizeEmail function returns the form of an email address which
+// attempts are recorded under, so that changing its case doesn't get around
+// a lockout.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Method to start a login attempt, before its password or code is checked.
+// The attempt is recorded as pending, and the ID of its row is returned
+// with the failed and pending attempts in the last window for the email
+// address, which haven't been cleared, and for the client IP address. The
+// row is inserted before the others are counted, so of several attempts
+// made at the same time, the last to count sees all of the others.
+func (m *LoginAttemptModel) Start(ctx context.Context, email, ip string, window time.Duration) (int, *LoginFailures, error) {
+	email = normalizeEmail(email)
+	spanCtx, span := startSpan(ctx, "LoginAttemptModel.Start", loginInsertQuery)
+	result, err := m.InsertStmt.ExecContext(spanCtx, email, ip)
+	endSpan(span, err)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	f := &LoginFailures{}
+	var last sql.NullTime
+
+	spanCtx, span = startSpan(ctx, "LoginAttemptModel.Start", loginAccountFailedQuery)
+	err = m.AccountFailedStmt.QueryRowContext(spanCtx, email, int(window.Seconds()), id).Scan(&f.Account, &last)
+	endSpan(span, err)
+	if err != nil {
+		return 0, nil, err
+	}
+	f.AccountLast = last.Time
+
+	spanCtx, span = startSpan(ctx, "LoginAttemptModel.Start", loginIPFailedQuery)
+	err = m.IPFailedStmt.QueryRowContext(spanCtx, ip, int(window.Seconds()), id).Scan(&f.IP, &last)
+	endSpan(span, err)
+	if err != nil {
+		return 0, nil, err
+	}
+	f.IPLast = last.Time
+	return int(id), f, nil
+}
+
+// Method to record the result of a login attempt started with Start. A
+// successful login clears the earlier failures for the email address.
+func (m *LoginAttemptModel) Finish(ctx context.Context, id int, result string) (err error) {
+	const query = `UPDATE login_attempts SET result = ? WHERE id = ?`
+	spanCtx, span := startSpan(ctx, "LoginAttemptModel.Finish", query)
+	_, err = m.DB.ExecContext(spanCtx, query, result, id)
+	endSpan(span, err)
+	if err != nil || result != LoginSucceeded {
+		return err
+	}
+
+	const emailQuery = `SELECT email FROM login_attempts WHERE id = ?`
+	var email string
+	spanCtx, span = startSpan(ctx, "LoginAttemptModel.Finish", emailQuery)
+	err = m.DB.QueryRowContext(spanCtx, emailQuery, id).Scan(&email)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	_, err = m.clear(ctx, email)
+	return err
+}
+
+// Method to unlock an account by clearing its failed attempts. It returns
+// the number of attempts cleared.
+func (m *LoginAttemptModel) Unlock(ctx context.Context, email string) (int64, error) {
+	return m.clear(ctx, normalizeEmail(email))
+}
+
+func (m *LoginAttemptModel) clear(ctx context.Context, email string) (n int64, err error) {
+	ctx, span := startSpan(ctx, "LoginAttemptModel.clear", loginClearQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, loginClearQuery, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Method to return the latest login attempts for an email address, newest
+// first, for auditing.
+func (m *LoginAttemptModel) List(ctx context.Context, email string, limit int) (attempts []*LoginAttempt, err error) {
+	const query = `SELECT id, email, ip, result, cleared, created FROM login_attempts
+	WHERE email = ? ORDER BY id DESC LIMIT ?`
+	ctx, span := startSpan(ctx, "LoginAttemptModel.List", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.DB.QueryContext(ctx, query, normalizeEmail(email), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts = []*LoginAttempt{}
+	for rows.Next() {
+		a := &LoginAttempt{}
+		err = rows.Scan(&a.ID, &a.Email, &a.IP, &a.Result, &a.Cleared, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Define a Migration type for a single schema change. Versions are taken from
+// the numeric prefix of the file name, e.g. 3 for 0003_api_tokens.sql.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Migrations returns the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		prefix, _, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("models: migration %s has no version prefix", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("models: migration %s has an invalid version prefix", base)
+		}
+		b, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version:    version,
+			Name:       base,
+			Statements: splitStatements(string(b)),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into its individual statements, as
+// the MySQL driver only runs one statement per call by default. Statements
+// must end with a semicolon at the end of a line, and "--" comment lines are
+// dropped.
+func splitStatements(src string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// Migrate applies every migration newer than the current schema version,
+// recording each one in the schema_migrations table, and returns the names
+// of the migrations it ran. MySQL commits schema changes implicitly, so a
+// failed migration has to be fixed up by hand.
+//
+// If baseline is greater than zero, migrations up to and including that
+// version are recorded as applied without being run. This is for databases
+// which were created by hand before migrations were tracked.
+func Migrate(ctx context.Context, db *sql.DB, baseline int) ([]string, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if m.Version > baseline {
+			for _, stmt := range m.Statements {
+				_, err = db.ExecContext(ctx, stmt)
+				if err != nil {
+					return applied, fmt.Errorf("models: migration %s: %w", m.Name, err)
+				}
+			}
+			applied = append(applied, m.Name)
+		}
+		_, err = db.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied)
+		VALUES(?,?,UTC_TIMESTAMP())`, m.Version, m.Name)
+		if err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	src := `-- A comment; with a semicolon
+CREATE TABLE t (
+    id INTEGER NOT NULL
+);
+
+CREATE INDEX idx ON t(id);
+ALTER TABLE t ADD COLUMN x INTEGER`
+
+	want := []string{
+		"CREATE TABLE t (\n    id INTEGER NOT NULL\n)",
+		"CREATE INDEX idx ON t(id)",
+		"ALTER TABLE t ADD COLUMN x INTEGER",
+	}
+
+	got := splitStatements(src)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has version %d; want %d", m.Name, m.Version, i+1)
+		}
+		if len(m.Statements) == 0 {
+			t.Errorf("migration %s has no statements", m.Name)
+		}
+		for _, stmt := range m.Statements {
+			if strings.HasSuffix(stmt, ";") {
+				t.Errorf("migration %s: statement %q still ends with a semicolon", m.Name, stmt)
+			}
+		}
+	}
+}
@@ -0,0 +1,6 @@
+-- Record the owner of each snippet. Snippets created before this migration
+-- have no owner and can't be changed through the API.
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user_id
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
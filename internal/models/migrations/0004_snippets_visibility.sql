@@ -0,0 +1,6 @@
+-- Public snippets are listed everywhere, unlisted ones only to those who have
+-- the link, and private ones only to their owner.
+ALTER TABLE snippets ADD COLUMN visibility ENUM('public', 'unlisted', 'private')
+    NOT NULL DEFAULT 'public';
+
+CREATE INDEX idx_snippets_user_id_created ON snippets(user_id, created);
@@ -0,0 +1,17 @@
+-- Snippets can contain several named files. The existing content of each
+-- snippet becomes its first file.
+CREATE TABLE snippet_files (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    language VARCHAR(50) NOT NULL,
+    content MEDIUMTEXT NOT NULL,
+    CONSTRAINT snippet_files_uc_name UNIQUE (snippet_id, name),
+    CONSTRAINT fk_snippet_files_snippet_id FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+INSERT INTO snippet_files (snippet_id, position, name, language, content)
+SELECT id, 0, 'snippet.txt', 'plaintext', content FROM snippets;
+
+ALTER TABLE snippets DROP COLUMN content;
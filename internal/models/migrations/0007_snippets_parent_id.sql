@@ -0,0 +1,6 @@
+-- Record the snippet a fork was copied from. Deleting the parent keeps its
+-- forks, they just lose the link.
+ALTER TABLE snippets ADD COLUMN parent_id INTEGER NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_parent_id
+    FOREIGN KEY (parent_id) REFERENCES snippets(id) ON DELETE SET NULL;
@@ -0,0 +1,17 @@
+-- Comments on snippets. A comment can reply to another comment on the same
+-- snippet and can point at a line of one of the snippet's files.
+CREATE TABLE comments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    parent_id INTEGER NULL,
+    file_name VARCHAR(255) NULL,
+    line INTEGER NULL,
+    body TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT fk_comments_snippet_id FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT fk_comments_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_comments_parent_id FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
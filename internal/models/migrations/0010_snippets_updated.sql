@@ -0,0 +1,6 @@
+-- Record when a snippet was last changed, for feeds and the API.
+ALTER TABLE snippets ADD COLUMN updated DATETIME NULL;
+
+UPDATE snippets SET updated = created;
+
+ALTER TABLE snippets MODIFY updated DATETIME NOT NULL;
@@ -0,0 +1,7 @@
+-- Profile details shown on a user's public page. The avatar is a small
+-- image stored along with its content type.
+ALTER TABLE users ADD COLUMN bio TEXT NULL;
+
+ALTER TABLE users ADD COLUMN avatar MEDIUMBLOB NULL;
+
+ALTER TABLE users ADD COLUMN avatar_type VARCHAR(50) NULL;
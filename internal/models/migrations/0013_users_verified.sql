@@ -0,0 +1,5 @@
+-- Users have to confirm their email address by following a link sent to it.
+-- Existing accounts are treated as verified.
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET verified = TRUE;
@@ -0,0 +1,19 @@
+-- Time-based one-time password (TOTP) secrets for two-factor authentication.
+-- The secret is encrypted by the application, and last_step records the
+-- last time step used so that a code can't be used twice.
+CREATE TABLE two_factor (
+    user_id INTEGER NOT NULL PRIMARY KEY,
+    secret VARBINARY(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT fk_two_factor_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- One-time recovery codes for users who lose their authenticator. Only the
+-- SHA-256 hash of each code is stored.
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, hash),
+    CONSTRAINT fk_recovery_codes_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
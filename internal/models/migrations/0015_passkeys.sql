@@ -0,0 +1,14 @@
+-- WebAuthn credentials (passkeys and security keys) for passwordless login.
+-- The credential column holds the public key, flags and signature counter
+-- as JSON, and credential_id is the ID the authenticator sends at login.
+CREATE TABLE passkeys (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential_id VARBINARY(1023) NOT NULL,
+    credential BLOB NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT passkeys_uc_credential_id UNIQUE (credential_id),
+    CONSTRAINT fk_passkeys_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+-- Every password login attempt, kept for auditing and for throttling
+-- password guessing. Failed attempts count towards a lockout until a later
+-- successful login, or an admin unlock, marks them as cleared.
+CREATE TABLE login_attempts (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    result VARCHAR(10) NOT NULL,
+    cleared BOOLEAN NOT NULL DEFAULT FALSE,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_email_created ON login_attempts(email, created);
+CREATE INDEX idx_login_attempts_ip_created ON login_attempts(ip, created);
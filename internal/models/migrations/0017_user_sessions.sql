@@ -0,0 +1,16 @@
+-- The logged-in sessions of each user. The scs sessions table is keyed by
+-- token only, so each logged-in session also stores the ID of its row here,
+-- which lets users see their sessions and log them out. A session whose row
+-- has been deleted is no longer logged in.
+CREATE TABLE user_sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expiry DATETIME NOT NULL,
+    CONSTRAINT fk_user_sessions_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
@@ -0,0 +1,18 @@
+-- "Remember me" tokens, which log a browser back in once its session has
+-- expired. A token is a selector, which finds the row, and a validator, of
+-- which only the SHA-256 hash is stored. Tokens are used once and replaced;
+-- used tokens are kept until they expire so that replaying one is noticed,
+-- with the time they were used, as a browser may send several requests at
+-- once with the same token.
+CREATE TABLE remember_tokens (
+    selector CHAR(16) NOT NULL PRIMARY KEY,
+    hash BINARY(32) NOT NULL,
+    user_id INTEGER NOT NULL,
+    session_id INTEGER NOT NULL,
+    used_at DATETIME NULL,
+    expiry DATETIME NOT NULL,
+    CONSTRAINT fk_remember_tokens_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_remember_tokens_user_id ON remember_tokens(user_id);
+CREATE INDEX idx_remember_tokens_session_id ON remember_tokens(session_id);
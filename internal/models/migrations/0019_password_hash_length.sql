@@ -0,0 +1,3 @@
+-- Password hashes were always 60 character bcrypt hashes. Argon2id hashes
+-- in PHC string format are longer, and hold their parameters.
+ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
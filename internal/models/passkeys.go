@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Define a Passkey type to hold a WebAuthn credential registered by a user.
+// Credential is the encoded public key, flags and signature counter, which
+// the caller interprets; the model only stores it.
+type Passkey struct {
+	ID           int
+	UserID       int
+	Name         string
+	CredentialID []byte
+	Credential   []byte
+	Created      time.Time
+	LastUsed     time.Time
+}
+
+// Define a PasskeyModel type wich wraps a sql.DB connection pool.
+type PasskeyModel struct {
+	DB         *sql.DB
+	ListStmt   *sql.Stmt
+	UpdateStmt *sql.Stmt
+}
+
+type PasskeyModelInterface interface {
+	Insert(ctx context.Context, userID int, name string, credentialID, credential []byte) error
+	ListForUser(ctx context.Context, userID int) ([]*Passkey, error)
+	Update(ctx context.Context, id int, credential []byte) error
+	Delete(ctx context.Context, id, userID int) error
+}
+
+// SQL statements used by the PasskeyModel at login.
+const (
+	passkeyListQuery = `SELECT id, user_id, name, credential_id, credential, created, last_used
+	FROM passkeys WHERE user_id = ? ORDER BY id`
+	passkeyUpdateQuery = `UPDATE passkeys SET credential = ?, last_used = UTC_TIMESTAMP() WHERE id = ?`
+)
+
+// Creates a constructor for a PasskeyModel, which includes prepared
+// statements.
+func NewPasskeyModel(db *sql.DB) (*PasskeyModel, error) {
+	listStmt, err := db.Prepare(passkeyListQuery)
+	if err != nil {
+		return nil, err
+	}
+	updateStmt, err := db.Prepare(passkeyUpdateQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyModel{
+		DB:         db,
+		ListStmt:   listStmt,
+		UpdateStmt: updateStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *PasskeyModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.ListStmt, m.UpdateStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to store a newly registered passkey for a user. If the credential
+// is already registered, ErrDuplicateCredential is returned.
+func (m *PasskeyModel) Insert(ctx context.Context, userID int, name string, credentialID, credential []byte) (err error) {
+	const query = `INSERT INTO passkeys (user_id, name, credential_id, credential, created)
+	VALUES(?,?,?,?,UTC_TIMESTAMP())`
+	ctx, span := startSpan(ctx, "PasskeyModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, name, credentialID, credential)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "passkeys_uc_credential_id") {
+				return ErrDuplicateCredential
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Method to return all the passkeys belonging to a user, oldest first.
+func (m *PasskeyModel) ListForUser(ctx context.Context, userID int) (passkeys []*Passkey, err error) {
+	ctx, span := startSpan(ctx, "PasskeyModel.ListForUser", passkeyListQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.ListStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys = []*Passkey{}
+	for rows.Next() {
+		p := &Passkey{}
+		var lastUsed sql.NullTime
+		err = rows.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.Credential, &p.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		p.LastUsed = lastUsed.Time
+		passkeys = append(passkeys, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// Method to store a passkey's credential after a login, which updates its
+// signature counter, and record that it has been used.
+func (m *PasskeyModel) Update(ctx context.Context, id int, credential []byte) (err error) {
+	ctx, span := startSpan(ctx, "PasskeyModel.Update", passkeyUpdateQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.UpdateStmt.ExecContext(ctx, credential, id)
+	return err
+}
+
+// Method to remove one of a user's passkeys. If the passkey doesn't exist or
+// belongs to someone else, ErrNoRecord is returned.
+func (m *PasskeyModel) Delete(ctx context.Context, id, userID int) (err error) {
+	const query = `DELETE FROM passkeys WHERE id = ? AND user_id = ?`
+	ctx, span := startSpan(ctx, "PasskeyModel.Delete", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
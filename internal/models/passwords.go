@@ -0,0 +1,206 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms supported by PasswordHasher.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// Salt and key lengths of argon2id hashes, in bytes.
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Upper bounds of the argon2id parameters, both configured and read from
+// stored hashes. A hash with a huge memory or time cost would take the
+// server down when its password is checked.
+const (
+	maxArgon2Time    = 10
+	maxArgon2Memory  = 1024 * 1024
+	maxArgon2Threads = 16
+)
+
+// Define a PasswordHasher type to hold how new passwords are hashed.
+// Hashes made with another algorithm, or with a lower cost, can still be
+// checked, and NeedsRehash reports them so they can be replaced.
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2id parameters: the number of passes, the memory in KiB and the
+	// degree of parallelism.
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+
+	dummyOnce sync.Once
+	dummy     []byte
+}
+
+// DefaultPasswordHasher returns a PasswordHasher which makes bcrypt hashes
+// with cost 12, like every password stored before hashing was
+// configurable. Its argon2id parameters are the OWASP recommendation.
+func DefaultPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:     Bcrypt,
+		BcryptCost:    12,
+		Argon2Time:    2,
+		Argon2Memory:  19 * 1024,
+		Argon2Threads: 1,
+	}
+}
+
+// Validate returns an error if the algorithm is unknown or its parameters
+// are out of range.
+func (h *PasswordHasher) Validate() error {
+	switch h.Algorithm {
+	case Bcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		if h.Argon2Time < 1 || h.Argon2Threads < 1 {
+			return errors.New("argon2id time and threads must be at least 1")
+		}
+		if h.Argon2Memory < 8*uint32(h.Argon2Threads) {
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		}
+		if h.Argon2Time > maxArgon2Time || h.Argon2Memory > maxArgon2Memory || h.Argon2Threads > maxArgon2Threads {
+			return fmt.Errorf("argon2id time, memory and threads must be at most %d, %d KiB and %d",
+				maxArgon2Time, maxArgon2Memory, maxArgon2Threads)
+		}
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q", h.Algorithm)
+	}
+	return nil
+}
+
+// Hash returns a hash of the password made with the configured algorithm.
+// Argon2id hashes use the PHC string format, so the parameters are stored
+// with the hash just like bcrypt's cost.
+func (h *PasswordHasher) Hash(password string) ([]byte, error) {
+	if h.Algorithm == Bcrypt {
+		return bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, argon2KeyLen)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.Argon2Memory, h.Argon2Time, h.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// Compare checks the password against a hash made with either algorithm.
+// If they don't match, ErrInvalidCredentials is returned.
+func (h *PasswordHasher) Compare(hash []byte, password string) error {
+	if !isArgon2id(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm, or
+// with a lower cost than the configured one.
+func (h *PasswordHasher) NeedsRehash(hash []byte) bool {
+	if h.Algorithm == Bcrypt {
+		if isArgon2id(hash) {
+			return true
+		}
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost < h.BcryptCost
+	}
+
+	if !isArgon2id(hash) {
+		return true
+	}
+	p, _, _, err := parseArgon2id(hash)
+	return err != nil || p.Argon2Time < h.Argon2Time || p.Argon2Memory < h.Argon2Memory ||
+		p.Argon2Threads < h.Argon2Threads
+}
+
+// The dummyHash method returns a hash of a random password, made with the
+// configured algorithm. Authenticate compares with it when there is no user
+// for an email address, so that it takes as long as a wrong password.
+func (h *PasswordHasher) dummyHash() []byte {
+	h.dummyOnce.Do(func() {
+		password := make([]byte, 32)
+		_, err := rand.Read(password)
+		if err == nil {
+			h.dummy, err = h.Hash(base64.RawStdEncoding.EncodeToString(password))
+		}
+		if err != nil {
+			// Comparing with an invalid hash returns at once, so fall
+			// back to a fixed hash rather than leak timing.
+			h.dummy = []byte("$2a$12$N7QWrk5V.t8vE3v.jNvQvOpW9nIg.cdYM6RpHzez5on/HDuw7IXC6")
+		}
+	})
+	return h.dummy
+}
+
+func isArgon2id(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+// The parseArgon2id function splits an argon2id hash in PHC string format
+// into its parameters, salt and key. Parameters above the maximums are
+// rejected rather than computed.
+func parseArgon2id(hash []byte) (*PasswordHasher, []byte, []byte, error) {
+	errMalformed := errors.New("models: malformed argon2id hash")
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, errMalformed
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, errMalformed
+	}
+	p := &PasswordHasher{Algorithm: Argon2id}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Argon2Memory, &p.Argon2Time, &p.Argon2Threads)
+	if err != nil || p.Argon2Time < 1 || p.Argon2Threads < 1 {
+		return nil, nil, nil, errMalformed
+	}
+	if p.Argon2Time > maxArgon2Time || p.Argon2Memory > maxArgon2Memory || p.Argon2Threads > maxArgon2Threads {
+		return nil, nil, nil, errors.New("models: argon2id hash parameters are too high")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errMalformed
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	// Low costs keep the test fast.
+	newHasher := func(algorithm string, cost int, time, memory uint32) *PasswordHasher {
+		return &PasswordHasher{
+			Algorithm:     algorithm,
+			BcryptCost:    cost,
+			Argon2Time:    time,
+			Argon2Memory:  memory,
+			Argon2Threads: 1,
+		}
+	}
+	bcrypt4 := newHasher(Bcrypt, 4, 1, 64)
+	bcrypt5 := newHasher(Bcrypt, 5, 1, 64)
+	argon64 := newHasher(Argon2id, 4, 1, 64)
+	argon128 := newHasher(Argon2id, 4, 1, 128)
+	argon64x2 := newHasher(Argon2id, 4, 2, 64)
+
+	hashes := map[*PasswordHasher][]byte{}
+	for _, h := range []*PasswordHasher{bcrypt4, bcrypt5, argon64, argon128, argon64x2} {
+		hash, err := h.Hash("pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[h] = hash
+	}
+
+	tests := []struct {
+		name        string
+		hasher      *PasswordHasher
+		hash        []byte
+		needsRehash bool
+	}{
+		{"bcrypt", bcrypt4, hashes[bcrypt4], false},
+		{"bcrypt higher cost", bcrypt4, hashes[bcrypt5], false},
+		{"bcrypt lower cost", bcrypt5, hashes[bcrypt4], true},
+		{"argon2id to bcrypt", bcrypt4, hashes[argon64], true},
+		{"bcrypt to argon2id", argon64, hashes[bcrypt4], true},
+		{"argon2id", argon64, hashes[argon64], false},
+		{"argon2id more memory", argon64, hashes[argon128], false},
+		{"argon2id less memory", argon128, hashes[argon64], true},
+		{"argon2id fewer passes", argon64x2, hashes[argon64], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Hashes made with any algorithm and cost can be checked.
+			err := tt.hasher.Compare(tt.hash, "pa$$word")
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+			err = tt.hasher.Compare(tt.hash, "wrong")
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got error %v; want ErrInvalidCredentials", err)
+			}
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.needsRehash {
+				t.Errorf("NeedsRehash = %t; want %t", got, tt.needsRehash)
+			}
+		})
+	}
+
+	// Malformed argon2id hashes, and hashes with parameters too high to
+	// compute, are errors rather than wrong passwords.
+	for _, hash := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$!",
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=4294967295,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=255$c2FsdA$a2V5",
+	} {
+		err := argon64.Compare([]byte(hash), "pa$$word")
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Compare(%q) = %v; want a malformed hash error", hash, err)
+		}
+		if !argon64.NeedsRehash([]byte(hash)) {
+			t.Errorf("NeedsRehash(%q) = false; want true", hash)
+		}
+	}
+}
+
+func TestPasswordHasherValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		alter func(h *PasswordHasher)
+		valid bool
+	}{
+		{"default", func(h *PasswordHasher) {}, true},
+		{"argon2id", func(h *PasswordHasher) { h.Algorithm = Argon2id }, true},
+		{"unknown algorithm", func(h *PasswordHasher) { h.Algorithm = "md5" }, false},
+		{"bcrypt cost too low", func(h *PasswordHasher) { h.BcryptCost = 3 }, false},
+		{"bcrypt cost too high", func(h *PasswordHasher) { h.BcryptCost = 32 }, false},
+		{"argon2id no passes", func(h *PasswordHasher) { h.Algorithm = Argon2id; h.Argon2Time = 0 }, false},
+		{"argon2id no threads", func(h *PasswordHasher) { h.Algorithm = Argon2id; h.Argon2Threads = 0 }, false},
+		{"argon2id too many passes", func(h *PasswordHasher) { h.Algorithm = Argon2id; h.Argon2Time = 11 }, false},
+		{"argon2id too much memory", func(h *PasswordHasher) { h.Algorithm = Argon2id; h.Argon2Memory = 2 * 1024 * 1024 }, false},
+		{"argon2id too many threads", func(h *PasswordHasher) { h.Algorithm = Argon2id; h.Argon2Threads = 17 }, false},
+		{"argon2id too little memory", func(h *PasswordHasher) {
+			h.Algorithm = Argon2id
+			h.Argon2Memory = 15
+			h.Argon2Threads = 2
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := DefaultPasswordHasher()
+			tt.alter(h)
+			err := h.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v; want valid = %t", err, tt.valid)
+			}
+		})
+	}
+}
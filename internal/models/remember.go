@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Define a RememberTokenModel type wich wraps a sql.DB connection pool. It
+// stores the "remember me" tokens which log browsers back in.
+type RememberTokenModel struct {
+	DB         *sql.DB
+	InsertStmt *sql.Stmt
+}
+
+type RememberTokenModelInterface interface {
+	Insert(ctx context.Context, userID, sessionID int, ttl time.Duration) (string, error)
+	Consume(ctx context.Context, token string, grace time.Duration) (int, error)
+	Delete(ctx context.Context, token string) error
+	DeleteForSession(ctx context.Context, sessionID int) error
+	DeleteForUser(ctx context.Context, userID, exceptSessionID int) error
+}
+
+// SQL statements used by the RememberTokenModel.
+const (
+	rememberInsertQuery = `INSERT INTO remember_tokens (selector, hash, user_id, session_id, expiry)
+	VALUES(?,?,?,?,DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+)
+
+// Creates a constructor for a RememberTokenModel, which includes prepared
+// statements.
+func NewRememberTokenModel(db *sql.DB) (*RememberTokenModel, error) {
+	insertStmt, err := db.Prepare(rememberInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &RememberTokenModel{
+		DB:         db,
+		InsertStmt: insertStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *RememberTokenModel) CloseAll() error {
+	return m.InsertStmt.Close()
+}
+
+// The splitRememberToken function returns the selector and validator of a
+// token.
+func splitRememberToken(token string) (string, string, bool) {
+	selector, validator, ok := strings.Cut(token, ".")
+	if !ok || len(selector) != 16 || validator == "" {
+		return "", "", false
+	}
+	return selector, validator, true
+}
+
+// Method to create a remember me token for a user, which logged in as the
+// session with the given ID and expires after ttl. It returns the plaintext
+// token, which is only ever sent in a cookie. Expired tokens of every user
+// are deleted at the same time.
+func (m *RememberTokenModel) Insert(ctx context.Context, userID, sessionID int, ttl time.Duration) (token string, err error) {
+	b := make([]byte, 12+32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	selector := base64.RawURLEncoding.EncodeToString(b[:12])
+	validator := base64.RawURLEncoding.EncodeToString(b[12:])
+
+	const cleanQuery = `DELETE FROM remember_tokens WHERE expiry <= UTC_TIMESTAMP()`
+	spanCtx, span := startSpan(ctx, "RememberTokenModel.clean", cleanQuery)
+	_, err = m.DB.ExecContext(spanCtx, cleanQuery)
+	endSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, span = startSpan(ctx, "RememberTokenModel.Insert", rememberInsertQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.InsertStmt.ExecContext(ctx, selector, hashToken(validator), userID, sessionID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return selector + "." + validator, nil
+}
+
+// Method to use up a token. It returns the ID of the user the token belongs
+// to. If the token doesn't exist, is wrong or has expired, ErrNoRecord is
+// returned. If the token was used less than grace ago, ErrTokenRecentlyUsed
+// is returned, and if it was used before that, the user's ID is returned
+// with ErrTokenReplayed.
+func (m *RememberTokenModel) Consume(ctx context.Context, token string, grace time.Duration) (int, error) {
+	selector, validator, ok := splitRememberToken(token)
+	if !ok {
+		return 0, ErrNoRecord
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row, so that two requests can't both use the same token.
+	const query = `SELECT hash, user_id, used_at > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+	FROM remember_tokens WHERE selector = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	var hash []byte
+	var userID int
+	var recent sql.NullBool
+	spanCtx, span := startSpan(ctx, "RememberTokenModel.Consume", query)
+	err = tx.QueryRowContext(spanCtx, query, int(grace.Seconds()), selector).Scan(&hash, &userID, &recent)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	if subtle.ConstantTimeCompare(hash, hashToken(validator)) != 1 {
+		return 0, ErrNoRecord
+	}
+	// used_at is NULL until the token has been used.
+	if recent.Valid {
+		if recent.Bool {
+			return 0, ErrTokenRecentlyUsed
+		}
+		return userID, ErrTokenReplayed
+	}
+
+	const useQuery = `UPDATE remember_tokens SET used_at = UTC_TIMESTAMP() WHERE selector = ?`
+	spanCtx, span = startSpan(ctx, "RememberTokenModel.use", useQuery)
+	_, err = tx.ExecContext(spanCtx, useQuery, selector)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Method to delete a token when its browser logs out.
+func (m *RememberTokenModel) Delete(ctx context.Context, token string) (err error) {
+	selector, _, ok := splitRememberToken(token)
+	if !ok {
+		return nil
+	}
+
+	const query = `DELETE FROM remember_tokens WHERE selector = ?`
+	ctx, span := startSpan(ctx, "RememberTokenModel.Delete", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, selector)
+	return err
+}
+
+// Method to delete the tokens of a session which has been logged out.
+func (m *RememberTokenModel) DeleteForSession(ctx context.Context, sessionID int) (err error) {
+	const query = `DELETE FROM remember_tokens WHERE session_id = ?`
+	ctx, span := startSpan(ctx, "RememberTokenModel.DeleteForSession", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, sessionID)
+	return err
+}
+
+// Method to delete all of a user's tokens except those of the session with
+// exceptSessionID, which may be 0 to delete every token.
+func (m *RememberTokenModel) DeleteForUser(ctx context.Context, userID, exceptSessionID int) (err error) {
+	const query = `DELETE FROM remember_tokens WHERE user_id = ? AND session_id <> ?`
+	ctx, span := startSpan(ctx, "RememberTokenModel.DeleteForUser", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, exceptSessionID)
+	return err
+}
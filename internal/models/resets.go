@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Define a PasswordResetModel type wich wraps a sql.DB connection pool. It
+// stores the tokens sent in password reset emails.
+type PasswordResetModel struct {
+	DB         *sql.DB
+	InsertStmt *sql.Stmt
+	GetStmt    *sql.Stmt
+	DeleteStmt *sql.Stmt
+}
+
+type PasswordResetModelInterface interface {
+	Insert(ctx context.Context, userID int, ttl time.Duration) (string, error)
+	UserID(ctx context.Context, plaintext string) (int, error)
+	Consume(ctx context.Context, plaintext string) (int, error)
+}
+
+// SQL statements used by the PasswordResetModel.
+const (
+	resetInsertQuery = `INSERT INTO password_resets (hash, user_id, expiry)
+	VALUES(?,?,DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	resetGetQuery    = `SELECT user_id FROM password_resets WHERE hash = ? AND expiry > UTC_TIMESTAMP()`
+	resetDeleteQuery = `DELETE FROM password_resets WHERE user_id = ? OR expiry <= UTC_TIMESTAMP()`
+)
+
+// Creates a constructor for a PasswordResetModel, which includes prepared
+// statements.
+func NewPasswordResetModel(db *sql.DB) (*PasswordResetModel, error) {
+	insertStmt, err := db.Prepare(resetInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	getStmt, err := db.Prepare(resetGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	deleteStmt, err := db.Prepare(resetDeleteQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordResetModel{
+		DB:         db,
+		InsertStmt: insertStmt,
+		GetStmt:    getStmt,
+		DeleteStmt: deleteStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *PasswordResetModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.InsertStmt, m.GetStmt, m.DeleteStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to create a password reset token for a user which expires after
+// ttl. It returns the plaintext token, which is only ever sent by email.
+func (m *PasswordResetModel) Insert(ctx context.Context, userID int, ttl time.Duration) (plaintext string, err error) {
+	b := make([]byte, 20)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+
+	ctx, span := startSpan(ctx, "PasswordResetModel.Insert", resetInsertQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.InsertStmt.ExecContext(ctx, hashToken(plaintext), userID, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// Method to return the ID of the user a token was sent to, without using the
+// token up. If the token doesn't exist or has expired, ErrNoRecord is
+// returned.
+func (m *PasswordResetModel) UserID(ctx context.Context, plaintext string) (int, error) {
+	var userID int
+	ctx, span := startSpan(ctx, "PasswordResetModel.UserID", resetGetQuery)
+	err := m.GetStmt.QueryRowContext(ctx, hashToken(plaintext)).Scan(&userID)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Method to use up a token. It returns the ID of the user the token was sent
+// to, and deletes every reset token of that user so that none of them can be
+// used again. If the token doesn't exist or has expired, ErrNoRecord is
+// returned.
+func (m *PasswordResetModel) Consume(ctx context.Context, plaintext string) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row, so that two requests can't both use the same token.
+	const query = resetGetQuery + " FOR UPDATE"
+	var userID int
+	spanCtx, span := startSpan(ctx, "PasswordResetModel.Consume", query)
+	err = tx.QueryRowContext(spanCtx, query, hashToken(plaintext)).Scan(&userID)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	spanCtx, span = startSpan(ctx, "PasswordResetModel.delete", resetDeleteQuery)
+	_, err = tx.StmtContext(spanCtx, m.DeleteStmt).ExecContext(spanCtx, userID)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
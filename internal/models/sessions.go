@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a UserSession type to hold the data for a logged-in session.
+type UserSession struct {
+	ID        int
+	UserID    int
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+	Expiry    time.Time
+}
+
+// Define a UserSessionModel type wich wraps a sql.DB connection pool. It
+// indexes the logged-in sessions by user, which the session store can't.
+type UserSessionModel struct {
+	DB        *sql.DB
+	GetStmt   *sql.Stmt
+	TouchStmt *sql.Stmt
+}
+
+type UserSessionModelInterface interface {
+	Insert(ctx context.Context, userID int, ip, userAgent string, ttl time.Duration) (int, error)
+	Get(ctx context.Context, id int) (*UserSession, error)
+	Touch(ctx context.Context, id int, ip string) error
+	ListForUser(ctx context.Context, userID int) ([]*UserSession, error)
+	Delete(ctx context.Context, id, userID int) error
+	DeleteForUser(ctx context.Context, userID, exceptID int) error
+}
+
+// SQL statements used by the UserSessionModel.
+const (
+	sessionGetQuery = `SELECT id, user_id, ip, user_agent, created, last_seen, expiry
+	FROM user_sessions WHERE id = ? AND expiry > UTC_TIMESTAMP()`
+	sessionTouchQuery = `UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ? WHERE id = ?`
+)
+
+// Creates a constructor for a UserSessionModel, which includes prepared
+// statements.
+func NewUserSessionModel(db *sql.DB) (*UserSessionModel, error) {
+	getStmt, err := db.Prepare(sessionGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	touchStmt, err := db.Prepare(sessionTouchQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &UserSessionModel{
+		DB:        db,
+		GetStmt:   getStmt,
+		TouchStmt: touchStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *UserSessionModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.GetStmt, m.TouchStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to record a new logged-in session for a user, which expires after
+// ttl like the session itself. Expired sessions of every user are deleted at
+// the same time. It returns the ID of the new session.
+func (m *UserSessionModel) Insert(ctx context.Context, userID int, ip, userAgent string, ttl time.Duration) (id int, err error) {
+	const query = `INSERT INTO user_sessions (user_id, ip, user_agent, created, last_seen, expiry)
+	VALUES(?,?,?,UTC_TIMESTAMP(),UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	const cleanQuery = `DELETE FROM user_sessions WHERE expiry <= UTC_TIMESTAMP()`
+
+	spanCtx, span := startSpan(ctx, "UserSessionModel.clean", cleanQuery)
+	_, err = m.DB.ExecContext(spanCtx, cleanQuery)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, span = startSpan(ctx, "UserSessionModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, ip, userAgent, int(ttl.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(lastID), nil
+}
+
+// Method to return a session. If it doesn't exist, has been logged out or
+// has expired, ErrNoRecord is returned.
+func (m *UserSessionModel) Get(ctx context.Context, id int) (*UserSession, error) {
+	s := &UserSession{}
+	ctx, span := startSpan(ctx, "UserSessionModel.Get", sessionGetQuery)
+	err := m.GetStmt.QueryRowContext(ctx, id).Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent,
+		&s.Created, &s.LastSeen, &s.Expiry)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Method to record that a session has been used from the given IP address.
+func (m *UserSessionModel) Touch(ctx context.Context, id int, ip string) (err error) {
+	ctx, span := startSpan(ctx, "UserSessionModel.Touch", sessionTouchQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.TouchStmt.ExecContext(ctx, ip, id)
+	return err
+}
+
+// Method to return a user's sessions which haven't expired, most recently
+// used first.
+func (m *UserSessionModel) ListForUser(ctx context.Context, userID int) (sessions []*UserSession, err error) {
+	const query = `SELECT id, user_id, ip, user_agent, created, last_seen, expiry
+	FROM user_sessions WHERE user_id = ? AND expiry > UTC_TIMESTAMP() ORDER BY last_seen DESC, id DESC`
+	ctx, span := startSpan(ctx, "UserSessionModel.ListForUser", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions = []*UserSession{}
+	for rows.Next() {
+		s := &UserSession{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen, &s.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Method to log out one of a user's sessions. If the session doesn't exist
+// or belongs to someone else, ErrNoRecord is returned.
+func (m *UserSessionModel) Delete(ctx context.Context, id, userID int) (err error) {
+	const query = `DELETE FROM user_sessions WHERE id = ? AND user_id = ?`
+	ctx, span := startSpan(ctx, "UserSessionModel.Delete", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Method to log out all of a user's sessions except the one with exceptID,
+// which may be 0 to log out every session.
+func (m *UserSessionModel) DeleteForUser(ctx context.Context, userID, exceptID int) (err error) {
+	const query = `DELETE FROM user_sessions WHERE user_id = ? AND id <> ?`
+	ctx, span := startSpan(ctx, "UserSessionModel.DeleteForUser", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, exceptID)
+	return err
+}
@@ -1,18 +1,58 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
 )
 
-// Define a Snippet type to hold the data for an individual snippet.
+// Visibility levels of a snippet.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// Define a Snippet type to hold the data for an individual snippet. The
+// struct tags control how a snippet is encoded by the JSON API. Files and
+// ParentID are only filled in by Get, and Excerpt only by Public.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID         int            `json:"id"`
+	UserID     int            `json:"user_id,omitempty"`
+	ParentID   int            `json:"parent_id,omitempty"`
+	Title      string         `json:"title"`
+	Visibility string         `json:"visibility"`
+	Created    time.Time      `json:"created"`
+	Updated    time.Time      `json:"updated"`
+	Expires    time.Time      `json:"expires"`
+	Stars      int            `json:"stars"`
+	Excerpt    string         `json:"-"`
+	Files      []*SnippetFile `json:"files,omitempty"`
+}
+
+// Define a SnippetFile type to hold one named file of a snippet.
+type SnippetFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// File returns the snippet's file with the given name, or nil if there is
+// no such file.
+func (s *Snippet) File(name string) *SnippetFile {
+	for _, f := range s.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// CanView reports whether the user with the given ID, or 0 for an anonymous
+// visitor, may see the snippet. Only private snippets are restricted.
+func (s *Snippet) CanView(userID int) bool {
+	return s.Visibility != VisibilityPrivate || (userID != 0 && s.UserID == userID)
 }
 
 // Define a SnippetModel type wich wraps a sql.DB connection pool.
@@ -22,38 +62,171 @@ type SnippetModel struct {
 	GetStmt    *sql.Stmt
 	LatestStmt *sql.Stmt
 	DeleteStmt *sql.Stmt
+	ListStmt   *sql.Stmt
+	CountStmt  *sql.Stmt
+	UpdateStmt *sql.Stmt
+	// Statements for the public snippets of everyone or of one user.
+	PublicStmt      *sql.Stmt
+	PublicCountStmt *sql.Stmt
+	// Statements for the snippet_files table.
+	FilesStmt       *sql.Stmt
+	InsertFileStmt  *sql.Stmt
+	DeleteFilesStmt *sql.Stmt
+	// Statements for forking snippets.
+	ForkStmt      *sql.Stmt
+	ForkFilesStmt *sql.Stmt
+	ForksStmt     *sql.Stmt
 }
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
-	Delete(id int) error
+	Insert(ctx context.Context, userID int, title string, files []*SnippetFile, expires int, visibility string) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	Latest(ctx context.Context) ([]*Snippet, error)
+	List(ctx context.Context, userID, limit, offset int) ([]*Snippet, int, error)
+	Update(ctx context.Context, id int, title string, files []*SnippetFile, expires int, visibility string) error
+	Public(ctx context.Context, userID, limit, offset int) ([]*Snippet, int, error)
+	Delete(ctx context.Context, id int) error
+	Fork(ctx context.Context, id, userID int) (int, error)
+	Forks(ctx context.Context, id, userID int) ([]*Snippet, error)
 }
 
+// SQL statements used by the SnippetModel. They are kept as constants so that
+// the query text can be attached to tracing spans.
+const (
+	snippetInsertQuery = `INSERT INTO snippets (user_id, title, visibility, created, updated, expires)
+	VALUES(NULLIF(?,0),?,?,UTC_TIMESTAMP(),UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP(),INTERVAL ? DAY))`
+	snippetGetQuery = `SELECT id, COALESCE(user_id,0), COALESCE(parent_id,0), title, visibility, created, updated, expires,
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	snippetLatestQuery = `SELECT id, COALESCE(user_id,0), title, visibility, created, updated, expires,
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' ORDER BY id DESC LIMIT 10`
+	snippetDeleteQuery = `DELETE FROM snippets WHERE id=?`
+	// With a user ID of 0 the list and count statements return the public
+	// snippets, otherwise all the snippets owned by that user.
+	snippetListQuery = `SELECT id, COALESCE(user_id,0), title, visibility, created, updated, expires,
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
+	FROM snippets WHERE expires > UTC_TIMESTAMP()
+	AND ((? = 0 AND visibility = 'public') OR user_id = ?)
+	ORDER BY id DESC LIMIT ? OFFSET ?`
+	snippetCountQuery = `SELECT COUNT(*) FROM snippets WHERE expires > UTC_TIMESTAMP()
+	AND ((? = 0 AND visibility = 'public') OR user_id = ?)`
+	snippetUpdateQuery = `UPDATE snippets SET title = ?, visibility = ?, updated = UTC_TIMESTAMP(),
+	expires = DATE_ADD(UTC_TIMESTAMP(),INTERVAL ? DAY) WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	// With a user ID of 0 the public statements return the public snippets
+	// of every user, otherwise only those of that user. The excerpt is the
+	// start of the first file.
+	snippetPublicQuery = `SELECT id, COALESCE(user_id,0), title, visibility, created, updated, expires,
+	(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id),
+	COALESCE((SELECT LEFT(content, 500) FROM snippet_files f WHERE f.snippet_id = snippets.id
+	ORDER BY position LIMIT 1), '')
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' AND (? = 0 OR user_id = ?)
+	ORDER BY id DESC LIMIT ? OFFSET ?`
+	snippetPublicCountQuery = `SELECT COUNT(*) FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' AND (? = 0 OR user_id = ?)`
+	snippetFilesQuery = `SELECT name, language, content FROM snippet_files
+	WHERE snippet_id = ? ORDER BY position`
+	snippetInsertFileQuery = `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	VALUES(?,?,?,?,?)`
+	snippetDeleteFilesQuery = `DELETE FROM snippet_files WHERE snippet_id = ?`
+	// A fork keeps the title, visibility and expiry date of its parent.
+	snippetForkQuery = `INSERT INTO snippets (user_id, parent_id, title, visibility, created, updated, expires)
+	SELECT ?, id, title, visibility, UTC_TIMESTAMP(), UTC_TIMESTAMP(), expires
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	snippetForkFilesQuery = `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	SELECT ?, position, name, language, content FROM snippet_files WHERE snippet_id = ?`
+	// Forks lists the public forks of a snippet along with the ones owned by
+	// the given user.
+	snippetForksQuery = `SELECT id, COALESCE(user_id,0), title, visibility, created, expires
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND parent_id = ?
+	AND (visibility = 'public' OR (? <> 0 AND user_id = ?))
+	ORDER BY id DESC`
+)
+
 // Creates a constructor for a SnippetModel, which includes prepared statements.
 // This is needed so we can reuse this statements and not recreate them on each call.
 func NewSnippetModel(db *sql.DB) (*SnippetModel, error) {
-	insertStmt, err := db.Prepare(`INSERT INTO snippets (title, content, created, expires)
-	VALUES(?,?,UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP(),INTERVAL ? DAY))`)
+	insertStmt, err := db.Prepare(snippetInsertQuery)
 	if err != nil {
 		return nil, err
 	}
-	getStmt, err := db.Prepare(`SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`)
+	getStmt, err := db.Prepare(snippetGetQuery)
 	if err != nil {
 		return nil, err
 	}
-	latestStmt, err := db.Prepare(`SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`)
+	latestStmt, err := db.Prepare(snippetLatestQuery)
 	if err != nil {
 		return nil, err
 	}
-	deleteStmt, err := db.Prepare(`DELETE FROM snippets WHERE id=?`)
+	deleteStmt, err := db.Prepare(snippetDeleteQuery)
 	if err != nil {
 		return nil, err
 	}
-	return &SnippetModel{db, insertStmt, getStmt, latestStmt, deleteStmt}, nil
+	listStmt, err := db.Prepare(snippetListQuery)
+	if err != nil {
+		return nil, err
+	}
+	countStmt, err := db.Prepare(snippetCountQuery)
+	if err != nil {
+		return nil, err
+	}
+	updateStmt, err := db.Prepare(snippetUpdateQuery)
+	if err != nil {
+		return nil, err
+	}
+	publicStmt, err := db.Prepare(snippetPublicQuery)
+	if err != nil {
+		return nil, err
+	}
+	publicCountStmt, err := db.Prepare(snippetPublicCountQuery)
+	if err != nil {
+		return nil, err
+	}
+	filesStmt, err := db.Prepare(snippetFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+	insertFileStmt, err := db.Prepare(snippetInsertFileQuery)
+	if err != nil {
+		return nil, err
+	}
+	deleteFilesStmt, err := db.Prepare(snippetDeleteFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+	forkStmt, err := db.Prepare(snippetForkQuery)
+	if err != nil {
+		return nil, err
+	}
+	forkFilesStmt, err := db.Prepare(snippetForkFilesQuery)
+	if err != nil {
+		return nil, err
+	}
+	forksStmt, err := db.Prepare(snippetForksQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &SnippetModel{
+		DB:         db,
+		InserStmt:  insertStmt,
+		GetStmt:    getStmt,
+		LatestStmt: latestStmt,
+		DeleteStmt: deleteStmt,
+		ListStmt:   listStmt,
+		CountStmt:  countStmt,
+		UpdateStmt: updateStmt,
+
+		PublicStmt:      publicStmt,
+		PublicCountStmt: publicCountStmt,
+
+		FilesStmt:       filesStmt,
+		InsertFileStmt:  insertFileStmt,
+		DeleteFilesStmt: deleteFilesStmt,
+
+		ForkStmt:      forkStmt,
+		ForkFilesStmt: forkFilesStmt,
+		ForksStmt:     forksStmt,
+	}, nil
 }
 
 // Closes all the prepared statements to ensuare that it is properly closed
@@ -75,40 +248,96 @@ func (s *SnippetModel) CloseAll() error {
 	if err != nil {
 		return err
 	}
+	err = s.ListStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = s.CountStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = s.UpdateStmt.Close()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []*sql.Stmt{s.PublicStmt, s.PublicCountStmt, s.FilesStmt, s.InsertFileStmt, s.DeleteFilesStmt,
+		s.ForkStmt, s.ForkFilesStmt, s.ForksStmt} {
+		err = stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Function to insert a new snippet into the database
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	// Use the Exec() method on the embedded connection pool to execute
-	// the statement
-	result, err := m.InserStmt.Exec(title, content, expires)
+// Function to insert a new snippet and its files into the database, owned by
+// the user with the given ID. Everything is inserted in one transaction, so
+// a snippet never exists without its files.
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, files []*SnippetFile, expires int, visibility string) (id int, err error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	spanCtx, span := startSpan(ctx, "SnippetModel.Insert", snippetInsertQuery)
+	// Use the ExecContext() method on the prepared statement to execute
+	// the statement inside the transaction
+	result, err := tx.StmtContext(spanCtx, m.InserStmt).ExecContext(spanCtx, userID, title, visibility, expires)
+	endSpan(span, err)
 	if err != nil {
 		return 0, err
 	}
 	// Use the LatestInserID() method on the result to get the ID of our newly
 	// inserted record in the snippets table.
-	id, err := result.LastInsertId()
+	lastID, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 	// The ID returned has the type int64, so we convert it to an int type
 	// before returning
-	return int(id), nil
+	id = int(lastID)
+
+	err = m.insertFiles(ctx, tx, id, files)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// The insertFiles method inserts the files of a snippet inside tx, in order.
+func (m *SnippetModel) insertFiles(ctx context.Context, tx *sql.Tx, snippetID int, files []*SnippetFile) error {
+	stmt := tx.StmtContext(ctx, m.InsertFileStmt)
+	for i, f := range files {
+		spanCtx, span := startSpan(ctx, "SnippetModel.insertFile", snippetInsertFileQuery)
+		_, err := stmt.ExecContext(spanCtx, snippetID, i, f.Name, f.Language, f.Content)
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Function to return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
-	// Use the QueryRow() method on the connection pool to execure our
-	// SQL statement, passing in the untrasted id variable as the value for
+// Function to return a specific snippet, including its files, based on its id.
+func (m *SnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	spanCtx, span := startSpan(ctx, "SnippetModel.Get", snippetGetQuery)
+	// Use the QueryRowContext() method on the prepared statement to execure
+	// our SQL statement, passing in the untrasted id variable as the value for
 	// the placeholder patameter. This returns a pointer to a sql.Row object
 	// wich holds the result from the database.
-	row := m.GetStmt.QueryRow(id)
+	row := m.GetStmt.QueryRowContext(spanCtx, id)
 	// Initialize a pointer to a new zeroed Snippet struct.
 	s := &Snippet{}
 	// Use row.Scan() to copy the values from each field in sql.Row to the
 	// corresponding field in the Snippet struct.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.UserID, &s.ParentID, &s.Title, &s.Visibility, &s.Created, &s.Updated, &s.Expires, &s.Stars)
+	endSpan(span, err)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -116,12 +345,46 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 			return nil, err
 		}
 	}
+
+	s.Files, err = m.files(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
+// The files method returns the files of a snippet, in order.
+func (m *SnippetModel) files(ctx context.Context, snippetID int) (files []*SnippetFile, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.files", snippetFilesQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.FilesStmt.QueryContext(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files = []*SnippetFile{}
+	for rows.Next() {
+		f := &SnippetFile{}
+		err = rows.Scan(&f.Name, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 // Function to return the 10 most recently created snippets.
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	rows, err := m.LatestStmt.Query()
+func (m *SnippetModel) Latest(ctx context.Context) (snippets []*Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Latest", snippetLatestQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.LatestStmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -129,10 +392,10 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	// properly cloesd before the Latest() method returns.
 	defer rows.Close()
 	// Initialize an empty slice to hold the Snippet sturcts.
-	snippets := []*Snippet{}
+	snippets = []*Snippet{}
 	for rows.Next() {
 		s := &Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Updated, &s.Expires, &s.Stars)
 		if err != nil {
 			return nil, err
 		}
@@ -144,8 +407,90 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	return snippets, nil
 }
 
-func (m *SnippetModel) Delete(id int) error {
-	_, err := m.DeleteStmt.Query(id)
+// Function to return a page of non-expired snippets, newest first, along with
+// the total number of matching snippets. If userID is 0 the public snippets
+// are listed, otherwise every snippet owned by that user.
+func (m *SnippetModel) List(ctx context.Context, userID, limit, offset int) (snippets []*Snippet, total int, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.List", snippetListQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = m.CountStmt.QueryRowContext(ctx, userID, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.ListStmt.QueryContext(ctx, userID, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	snippets = []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Updated, &s.Expires, &s.Stars)
+		if err != nil {
+			return nil, 0, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return snippets, total, nil
+}
+
+// Function to replace the title, files and visibility of a snippet and push
+// its expiry date out by the given number of days, in one transaction. If
+// the snippet doesn't exist or has expired, ErrNoRecord is returned and its
+// files are left alone.
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, files []*SnippetFile, expires int, visibility string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row first, rather than checking the rows affected by the
+	// update, which MySQL only counts if they changed.
+	const lockQuery = `SELECT id FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ? FOR UPDATE`
+	var found int
+	spanCtx, span := startSpan(ctx, "SnippetModel.lock", lockQuery)
+	err = tx.QueryRowContext(spanCtx, lockQuery, id).Scan(&found)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	spanCtx, span = startSpan(ctx, "SnippetModel.Update", snippetUpdateQuery)
+	_, err = tx.StmtContext(spanCtx, m.UpdateStmt).ExecContext(spanCtx, title, visibility, expires, id)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	spanCtx, span = startSpan(ctx, "SnippetModel.deleteFiles", snippetDeleteFilesQuery)
+	_, err = tx.StmtContext(spanCtx, m.DeleteFilesStmt).ExecContext(spanCtx, id)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	err = m.insertFiles(ctx, tx, id, files)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Delete", snippetDeleteQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DeleteStmt.ExecContext(ctx, id)
 	if err != nil {
 		return ErrNoRecord
 	} else {
@@ -153,3 +498,108 @@ func (m *SnippetModel) Delete(id int) error {
 	}
 
 }
+
+// Function to copy a snippet and its files into a new snippet owned by the
+// user with the given ID, recording the original as its parent. It returns
+// the ID of the fork, or ErrNoRecord if the snippet doesn't exist. Callers
+// are responsible for checking that the user may see the snippet.
+func (m *SnippetModel) Fork(ctx context.Context, id, userID int) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	spanCtx, span := startSpan(ctx, "SnippetModel.Fork", snippetForkQuery)
+	result, err := tx.StmtContext(spanCtx, m.ForkStmt).ExecContext(spanCtx, userID, id)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, ErrNoRecord
+	}
+	forkID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	spanCtx, span = startSpan(ctx, "SnippetModel.forkFiles", snippetForkFilesQuery)
+	_, err = tx.StmtContext(spanCtx, m.ForkFilesStmt).ExecContext(spanCtx, forkID, id)
+	endSpan(span, err)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int(forkID), nil
+}
+
+// Function to return the non-expired forks of a snippet that the user with
+// the given ID, or 0 for an anonymous visitor, may see, newest first.
+func (m *SnippetModel) Forks(ctx context.Context, id, userID int) (snippets []*Snippet, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Forks", snippetForksQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.ForksStmt.QueryContext(ctx, id, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets = []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{ParentID: id}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// Function to return a page of non-expired public snippets, newest first,
+// along with the total number of them. If userID isn't 0 only the snippets
+// owned by that user are returned. Each snippet has an excerpt of its first
+// file.
+func (m *SnippetModel) Public(ctx context.Context, userID, limit, offset int) (snippets []*Snippet, total int, err error) {
+	ctx, span := startSpan(ctx, "SnippetModel.Public", snippetPublicQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = m.PublicCountStmt.QueryRowContext(ctx, userID, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.PublicStmt.QueryContext(ctx, userID, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	snippets = []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Updated, &s.Expires,
+			&s.Stars, &s.Excerpt)
+		if err != nil {
+			return nil, 0, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return snippets, total, nil
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Define a StarModel type wich wraps a sql.DB connection pool.
+type StarModel struct {
+	DB         *sql.DB
+	InsertStmt *sql.Stmt
+	DeleteStmt *sql.Stmt
+	ExistsStmt *sql.Stmt
+	ListStmt   *sql.Stmt
+	CountStmt  *sql.Stmt
+}
+
+type StarModelInterface interface {
+	Star(ctx context.Context, userID, snippetID int) error
+	Unstar(ctx context.Context, userID, snippetID int) error
+	IsStarred(ctx context.Context, userID, snippetID int) (bool, error)
+	List(ctx context.Context, userID, limit, offset int) ([]*Snippet, int, error)
+}
+
+// SQL statements used by the StarModel. Starring a snippet twice is not an
+// error, so the insert ignores duplicate keys.
+const (
+	starInsertQuery = `INSERT IGNORE INTO stars (user_id, snippet_id, created)
+	VALUES(?,?,UTC_TIMESTAMP())`
+	starDeleteQuery = `DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`
+	starExistsQuery = `SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)`
+	// Starred snippets which have since expired or been made private by
+	// someone else are left out of the list.
+	starListQuery = `SELECT s.id, COALESCE(s.user_id,0), s.title, s.visibility, s.created, s.expires,
+	(SELECT COUNT(*) FROM stars c WHERE c.snippet_id = s.id)
+	FROM stars st JOIN snippets s ON s.id = st.snippet_id
+	WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP()
+	AND (s.visibility <> 'private' OR s.user_id = st.user_id)
+	ORDER BY st.created DESC, s.id DESC LIMIT ? OFFSET ?`
+	starCountQuery = `SELECT COUNT(*)
+	FROM stars st JOIN snippets s ON s.id = st.snippet_id
+	WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP()
+	AND (s.visibility <> 'private' OR s.user_id = st.user_id)`
+)
+
+// Creates a constructor for a StarModel, which includes prepared statements.
+func NewStarModel(db *sql.DB) (*StarModel, error) {
+	insertStmt, err := db.Prepare(starInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	deleteStmt, err := db.Prepare(starDeleteQuery)
+	if err != nil {
+		return nil, err
+	}
+	existsStmt, err := db.Prepare(starExistsQuery)
+	if err != nil {
+		return nil, err
+	}
+	listStmt, err := db.Prepare(starListQuery)
+	if err != nil {
+		return nil, err
+	}
+	countStmt, err := db.Prepare(starCountQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &StarModel{
+		DB:         db,
+		InsertStmt: insertStmt,
+		DeleteStmt: deleteStmt,
+		ExistsStmt: existsStmt,
+		ListStmt:   listStmt,
+		CountStmt:  countStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *StarModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.InsertStmt, m.DeleteStmt, m.ExistsStmt, m.ListStmt, m.CountStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to star a snippet for a user. Starring an already starred snippet
+// does nothing.
+func (m *StarModel) Star(ctx context.Context, userID, snippetID int) (err error) {
+	ctx, span := startSpan(ctx, "StarModel.Star", starInsertQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.InsertStmt.ExecContext(ctx, userID, snippetID)
+	return err
+}
+
+// Method to remove a user's star from a snippet. Unstarring a snippet which
+// isn't starred does nothing.
+func (m *StarModel) Unstar(ctx context.Context, userID, snippetID int) (err error) {
+	ctx, span := startSpan(ctx, "StarModel.Unstar", starDeleteQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DeleteStmt.ExecContext(ctx, userID, snippetID)
+	return err
+}
+
+// Method to check whether a user has starred a snippet.
+func (m *StarModel) IsStarred(ctx context.Context, userID, snippetID int) (starred bool, err error) {
+	ctx, span := startSpan(ctx, "StarModel.IsStarred", starExistsQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = m.ExistsStmt.QueryRowContext(ctx, userID, snippetID).Scan(&starred)
+	return starred, err
+}
+
+// Method to return a page of the snippets a user has starred, most recently
+// starred first, along with the total number of starred snippets.
+func (m *StarModel) List(ctx context.Context, userID, limit, offset int) (snippets []*Snippet, total int, err error) {
+	ctx, span := startSpan(ctx, "StarModel.List", starListQuery)
+	defer func() { endSpan(span, err) }()
+
+	err = m.CountStmt.QueryRowContext(ctx, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.ListStmt.QueryContext(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	snippets = []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Visibility, &s.Created, &s.Expires, &s.Stars)
+		if err != nil {
+			return nil, 0, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return snippets, total, nil
+}
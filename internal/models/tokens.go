@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes which can be granted to an API token.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+)
+
+// Every plaintext token starts with this prefix, so that leaked tokens are
+// easy to recognise.
+const tokenPrefix = "sbx_"
+
+// Define an APIToken type to hold the data for a personal API token. The
+// plaintext token is never stored, only its hash.
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// HasScope reports whether the token has been granted the given scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Define a TokenModel type wich wraps a sql.DB connection pool.
+type TokenModel struct {
+	DB         *sql.DB
+	InsertStmt *sql.Stmt
+	GetStmt    *sql.Stmt
+	TouchStmt  *sql.Stmt
+	ListStmt   *sql.Stmt
+	DeleteStmt *sql.Stmt
+}
+
+type TokenModelInterface interface {
+	Insert(ctx context.Context, userID int, name string, scopes []string) (string, error)
+	GetByToken(ctx context.Context, plaintext string) (*APIToken, error)
+	ListForUser(ctx context.Context, userID int) ([]*APIToken, error)
+	Delete(ctx context.Context, id, userID int) error
+}
+
+// SQL statements used by the TokenModel.
+const (
+	tokenInsertQuery = `INSERT INTO api_tokens (user_id, name, hash, scopes, created)
+	VALUES(?,?,?,?,UTC_TIMESTAMP())`
+	tokenGetQuery = `SELECT t.id, t.user_id, t.name, t.scopes, t.created, t.last_used
+	FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.hash = ? AND NOT u.disabled`
+	tokenTouchQuery = `UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`
+	tokenListQuery  = `SELECT id, user_id, name, scopes, created, last_used
+	FROM api_tokens WHERE user_id = ? ORDER BY id DESC`
+	tokenDeleteQuery = `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`
+)
+
+// Creates a constructor for a TokenModel, which includes prepared statements.
+func NewTokenModel(db *sql.DB) (*TokenModel, error) {
+	insertStmt, err := db.Prepare(tokenInsertQuery)
+	if err != nil {
+		return nil, err
+	}
+	getStmt, err := db.Prepare(tokenGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	touchStmt, err := db.Prepare(tokenTouchQuery)
+	if err != nil {
+		return nil, err
+	}
+	listStmt, err := db.Prepare(tokenListQuery)
+	if err != nil {
+		return nil, err
+	}
+	deleteStmt, err := db.Prepare(tokenDeleteQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenModel{
+		DB:         db,
+		InsertStmt: insertStmt,
+		GetStmt:    getStmt,
+		TouchStmt:  touchStmt,
+		ListStmt:   listStmt,
+		DeleteStmt: deleteStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *TokenModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.InsertStmt, m.GetStmt, m.TouchStmt, m.ListStmt, m.DeleteStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashToken returns the SHA-256 hash of a plaintext token. Tokens have 160
+// bits of entropy, so a fast unsalted hash is enough here.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Method to create a new API token for a user. It returns the plaintext
+// token, which is the only time it is ever available.
+func (m *TokenModel) Insert(ctx context.Context, userID int, name string, scopes []string) (plaintext string, err error) {
+	b := make([]byte, 20)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext = tokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+
+	ctx, span := startSpan(ctx, "TokenModel.Insert", tokenInsertQuery)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.InsertStmt.ExecContext(ctx, userID, name, hashToken(plaintext), strings.Join(scopes, ","))
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// Method to look up the token matching a plaintext token and record that it
+// has been used. If there is no such token, or its user has been disabled,
+// ErrNoRecord is returned.
+func (m *TokenModel) GetByToken(ctx context.Context, plaintext string) (t *APIToken, err error) {
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		return nil, ErrNoRecord
+	}
+
+	spanCtx, span := startSpan(ctx, "TokenModel.GetByToken", tokenGetQuery)
+	t = &APIToken{}
+	var scopes string
+	var lastUsed sql.NullTime
+	err = m.GetStmt.QueryRowContext(spanCtx, hashToken(plaintext)).
+		Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &lastUsed)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	t.LastUsed = lastUsed.Time
+
+	spanCtx, span = startSpan(ctx, "TokenModel.Touch", tokenTouchQuery)
+	_, err = m.TouchStmt.ExecContext(spanCtx, t.ID)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Method to return all the API tokens belonging to a user, newest first.
+func (m *TokenModel) ListForUser(ctx context.Context, userID int) (tokens []*APIToken, err error) {
+	ctx, span := startSpan(ctx, "TokenModel.ListForUser", tokenListQuery)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := m.ListStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens = []*APIToken{}
+	for rows.Next() {
+		t := &APIToken{}
+		var scopes string
+		var lastUsed sql.NullTime
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Method to revoke one of a user's API tokens. If the token doesn't exist or
+// belongs to someone else, ErrNoRecord is returned.
+func (m *TokenModel) Delete(ctx context.Context, id, userID int) (err error) {
+	ctx, span := startSpan(ctx, "TokenModel.Delete", tokenDeleteQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DeleteStmt.ExecContext(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
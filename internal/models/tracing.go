@@ -0,0 +1,34 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com.scottyfionnghall.snippetbox/internal/models")
+
+// startSpan starts a client span for the execution of a single SQL statement.
+// The span is a child of whatever span is stored in ctx, so queries show up
+// under the request that caused them.
+func startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMySQL, semconv.DBQueryText(query)),
+	)
+}
+
+// endSpan records err on the span, if any, and ends it. A missing row is an
+// expected outcome and isn't treated as an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+// The number of recovery codes a user gets when enabling two-factor
+// authentication.
+const recoveryCodeCount = 10
+
+// Define a TwoFactor type to hold a user's two-factor authentication
+// settings. The secret is encrypted by the caller, and a secret which isn't
+// enabled yet belongs to an unfinished enrolment.
+type TwoFactor struct {
+	UserID   int
+	Secret   []byte
+	Enabled  bool
+	LastStep int64
+}
+
+// Define a TwoFactorModel type wich wraps a sql.DB connection pool.
+type TwoFactorModel struct {
+	DB          *sql.DB
+	GetStmt     *sql.Stmt
+	UseStepStmt *sql.Stmt
+	UseCodeStmt *sql.Stmt
+}
+
+type TwoFactorModelInterface interface {
+	Get(ctx context.Context, userID int) (*TwoFactor, error)
+	SetSecret(ctx context.Context, userID int, secret []byte) error
+	Enable(ctx context.Context, userID int, step int64) ([]string, error)
+	Disable(ctx context.Context, userID int) error
+	UseStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+	RecoveryCodesLeft(ctx context.Context, userID int) (int, error)
+}
+
+// SQL statements used by the TwoFactorModel at login.
+const (
+	twoFactorGetQuery     = `SELECT user_id, secret, enabled, last_step FROM two_factor WHERE user_id = ?`
+	twoFactorUseStepQuery = `UPDATE two_factor SET last_step = ?
+	WHERE user_id = ? AND enabled AND last_step < ?`
+	twoFactorUseCodeQuery = `DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`
+)
+
+// Creates a constructor for a TwoFactorModel, which includes prepared
+// statements.
+func NewTwoFactorModel(db *sql.DB) (*TwoFactorModel, error) {
+	getStmt, err := db.Prepare(twoFactorGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	useStepStmt, err := db.Prepare(twoFactorUseStepQuery)
+	if err != nil {
+		return nil, err
+	}
+	useCodeStmt, err := db.Prepare(twoFactorUseCodeQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorModel{
+		DB:          db,
+		GetStmt:     getStmt,
+		UseStepStmt: useStepStmt,
+		UseCodeStmt: useCodeStmt,
+	}, nil
+}
+
+// Closes all the prepared statements
+func (m *TwoFactorModel) CloseAll() error {
+	for _, stmt := range []*sql.Stmt{m.GetStmt, m.UseStepStmt, m.UseCodeStmt} {
+		err := stmt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method to return a user's two-factor settings. If the user has never
+// started enrolling, ErrNoRecord is returned.
+func (m *TwoFactorModel) Get(ctx context.Context, userID int) (*TwoFactor, error) {
+	tf := &TwoFactor{}
+	ctx, span := startSpan(ctx, "TwoFactorModel.Get", twoFactorGetQuery)
+	err := m.GetStmt.QueryRowContext(ctx, userID).Scan(&tf.UserID, &tf.Secret, &tf.Enabled, &tf.LastStep)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Method to store a new, not yet enabled, secret for a user. Callers must
+// check that two-factor authentication isn't already enabled.
+func (m *TwoFactorModel) SetSecret(ctx context.Context, userID int, secret []byte) (err error) {
+	const query = `INSERT INTO two_factor (user_id, secret) VALUES(?,?)
+	ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled = FALSE, last_step = 0`
+	ctx, span := startSpan(ctx, "TwoFactorModel.SetSecret", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, secret)
+	return err
+}
+
+// Method to enable two-factor authentication once the user has entered a
+// code for the given time step. It replaces any old recovery codes and
+// returns the new ones in plaintext, which is the only time they are ever
+// available.
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, step int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const query = `UPDATE two_factor SET enabled = TRUE, last_step = ? WHERE user_id = ?`
+	spanCtx, span := startSpan(ctx, "TwoFactorModel.Enable", query)
+	result, err := tx.ExecContext(spanCtx, query, step, userID)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrNoRecord
+	}
+
+	const deleteQuery = `DELETE FROM recovery_codes WHERE user_id = ?`
+	spanCtx, span = startSpan(ctx, "TwoFactorModel.deleteCodes", deleteQuery)
+	_, err = tx.ExecContext(spanCtx, deleteQuery, userID)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	const insertQuery = `INSERT INTO recovery_codes (user_id, hash) VALUES(?,?)`
+	for _, code := range codes {
+		spanCtx, span = startSpan(ctx, "TwoFactorModel.insertCode", insertQuery)
+		_, err = tx.ExecContext(spanCtx, insertQuery, userID, hashToken(normalizeRecoveryCode(code)))
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Method to turn off two-factor authentication, removing the secret and
+// the recovery codes.
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int) (err error) {
+	const query = `DELETE two_factor, recovery_codes FROM two_factor
+	LEFT JOIN recovery_codes ON recovery_codes.user_id = two_factor.user_id
+	WHERE two_factor.user_id = ?`
+	ctx, span := startSpan(ctx, "TwoFactorModel.Disable", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Method to record that a code for the given time step was used. It returns
+// false if a code for the same or a later step has been used before, so
+// that a code can't be replayed.
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (ok bool, err error) {
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseStep", twoFactorUseStepQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.UseStepStmt.ExecContext(ctx, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Method to use up a recovery code. It returns false if the code isn't one
+// of the user's unused codes. Spaces, dashes and case are ignored.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (ok bool, err error) {
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseRecoveryCode", twoFactorUseCodeQuery)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.UseCodeStmt.ExecContext(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Method to count a user's unused recovery codes.
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (n int, err error) {
+	const query = `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`
+	ctx, span := startSpan(ctx, "TwoFactorModel.RecoveryCodesLeft", query)
+	defer func() { endSpan(span, err) }()
+
+	err = m.DB.QueryRowContext(ctx, query, userID).Scan(&n)
+	return n, err
+}
+
+// normalizeRecoveryCode removes the characters which users may add or drop
+// when typing a recovery code.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
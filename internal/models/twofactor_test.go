@@ -0,0 +1,12 @@
+package models
+
+import "testing"
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"abcd-efgh", "ABCD-EFGH", "abcd efgh", " abcdefgh"} {
+		got := normalizeRecoveryCode(code)
+		if got != "abcdefgh" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q; want %q", code, got, "abcdefgh")
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package models
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -16,36 +18,69 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Disabled       bool
+	Verified       bool
+	// Bio and HasAvatar are only filled in by Get.
+	Bio       string
+	HasAvatar bool
 }
 
 type UserModel struct {
 	InserStmt *sql.Stmt
 	AuthStmt  *sql.Stmt
 	ExistStmt *sql.Stmt
+	GetStmt   *sql.Stmt
 	DB        *sql.DB
+	// Hasher hashes new passwords, and checks stored ones.
+	Hasher *PasswordHasher
 }
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
+	Insert(ctx context.Context, name, email, password string) error
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	UpdateBio(ctx context.Context, id int, bio string) error
+	SetAvatar(ctx context.Context, id int, image []byte, contentType string) error
+	Avatar(ctx context.Context, id int) ([]byte, string, error)
+	CheckPassword(ctx context.Context, id int, password string) error
+	UpdateName(ctx context.Context, id int, name string) error
+	UpdateEmail(ctx context.Context, id int, email string) error
+	SetPassword(ctx context.Context, id int, password string) error
+	SetVerified(ctx context.Context, id int) error
+	InsertExternal(ctx context.Context, name, email string) (int, error)
 }
 
-func NewUserModel(db *sql.DB) (*UserModel, error) {
-	insertSmt, err := db.Prepare(`INSERT INTO users (name, email, hashed_password, created)
-	VALUES(?,?,?,UTC_TIMESTAMP())`)
+// SQL statements used by the UserModel.
+const (
+	userInsertQuery = `INSERT INTO users (name, email, hashed_password, created)
+	VALUES(?,?,?,UTC_TIMESTAMP())`
+	userAuthQuery   = "SELECT id, hashed_password FROM users WHERE email = ? AND NOT disabled"
+	userExistsQuery = "SELECT EXISTS(SELECT true FROM users WHERE id = ? AND NOT disabled)"
+	userGetQuery    = `SELECT id, name, email, created, disabled, verified, COALESCE(bio,''), avatar IS NOT NULL
+	FROM users WHERE id = ?`
+)
+
+func NewUserModel(db *sql.DB, hasher *PasswordHasher) (*UserModel, error) {
+	insertSmt, err := db.Prepare(userInsertQuery)
 	if err != nil {
 		return nil, err
 	}
-	authStmt, err := db.Prepare("SELECT id, hashed_password FROM users WHERE email = ?")
+	authStmt, err := db.Prepare(userAuthQuery)
 	if err != nil {
 		return nil, err
 	}
-	existStmt, err := db.Prepare("SELECT EXISTS(SELECT true FROM users WHERE id = ?)")
+	existStmt, err := db.Prepare(userExistsQuery)
 	if err != nil {
 		return nil, err
 	}
-	return &UserModel{InserStmt: insertSmt, AuthStmt: authStmt, ExistStmt: existStmt, DB: db}, nil
+	getStmt, err := db.Prepare(userGetQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &UserModel{InserStmt: insertSmt, AuthStmt: authStmt, ExistStmt: existStmt, GetStmt: getStmt, DB: db,
+		Hasher: hasher}, nil
 }
 
 func (u *UserModel) CloseAll() error {
@@ -57,18 +92,28 @@ func (u *UserModel) CloseAll() error {
 	if err != nil {
 		return err
 	}
+	err = u.ExistStmt.Close()
+	if err != nil {
+		return err
+	}
+	err = u.GetStmt.Close()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 // Method to insert a new user into database
-func (m *UserModel) Insert(name, email, password string) error {
-	// Create a bcrypt hash of the plain-text password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (err error) {
+	// Create a hash of the plain-text password
+	hashedPassword, err := m.Hasher.Hash(password)
 	if err != nil {
 		return err
 	}
-	// Use the Exec() method
-	_, err = m.InserStmt.Exec(name, email, string(hashedPassword))
+	ctx, span := startSpan(ctx, "UserModel.Insert", userInsertQuery)
+	defer func() { endSpan(span, err) }()
+	// Use the ExecContext() method
+	_, err = m.InserStmt.ExecContext(ctx, name, email, string(hashedPassword))
 	if err != nil {
 		// If this returns an error, we use the errors.As() to check
 		// whether the error has the type *mysql.MySQLError. If it does,
@@ -86,34 +131,255 @@ func (m *UserModel) Insert(name, email, password string) error {
 }
 
 // Method to authinticate the user. Verifes whether a user exists with the
-// provided email address and password. Returns relevant user ID.
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// provided email address and password. Returns relevant user ID. A stored
+// hash made with an older algorithm or a lower cost is replaced.
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
-	err := m.AuthStmt.QueryRow(email).Scan(&id, &hashedPassword)
+	spanCtx, span := startSpan(ctx, "UserModel.Authenticate", userAuthQuery)
+	err := m.AuthStmt.QueryRowContext(spanCtx, email).Scan(&id, &hashedPassword)
+	endSpan(span, err)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			// Compare against a dummy hash, so that unknown email addresses
+			// take as long as wrong passwords and can't be told apart.
+			m.Hasher.Compare(m.Hasher.dummyHash(), password)
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
 		}
 	}
 	// Check whether the hashed password and plain-text password provided match.
-	// If the don't, we return the ErrInvalidCredentials error.
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	// If the don't, Compare returns the ErrInvalidCredentials error.
+	err = m.Hasher.Compare(hashedPassword, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		} else {
-			return 0, err
-		}
+		return 0, err
+	}
+	if m.Hasher.NeedsRehash(hashedPassword) {
+		// The old hash still works, so a failed upgrade doesn't fail the
+		// login. It is recorded on the span and retried next time.
+		m.rehash(ctx, id, hashedPassword, password)
 	}
 	return id, nil
 }
 
+// Method to replace a user's password hash with one made with the
+// configured algorithm and cost, unless the password has been changed
+// since the old hash was read.
+func (m *UserModel) rehash(ctx context.Context, id int, oldHash []byte, password string) (err error) {
+	const query = "UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?"
+	ctx, span := startSpan(ctx, "UserModel.rehash", query)
+	defer func() { endSpan(span, err) }()
+
+	hashedPassword, err := m.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.ExecContext(ctx, query, string(hashedPassword), id, string(oldHash))
+	return err
+}
+
 // Method to check if the user with a specific ID exists
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	var exists bool
-	err := m.ExistStmt.QueryRow(id).Scan(&exists)
+	ctx, span := startSpan(ctx, "UserModel.Exists", userExistsQuery)
+	err := m.ExistStmt.QueryRowContext(ctx, id).Scan(&exists)
+	endSpan(span, err)
 	return exists, err
 }
+
+// Method to return the user with a specific ID. If there is no such user,
+// ErrNoRecord is returned.
+func (m *UserModel) Get(ctx context.Context, id int) (*User, error) {
+	u := &User{}
+	ctx, span := startSpan(ctx, "UserModel.Get", userGetQuery)
+	err := m.GetStmt.QueryRowContext(ctx, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Disabled,
+		&u.Verified, &u.Bio, &u.HasAvatar)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// Method to return the user with a specific email address. It is only used
+// by admin tooling and password resets, so the statement isn't prepared.
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	const query = "SELECT id, name, email, created, disabled, verified FROM users WHERE email = ?"
+	u := &User{}
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail", query)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Disabled, &u.Verified)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// Method to check a user's current password, for example before changing
+// their account details. If the password doesn't match, or the user doesn't
+// exist, ErrInvalidCredentials is returned.
+func (m *UserModel) CheckPassword(ctx context.Context, id int, password string) error {
+	const query = "SELECT hashed_password FROM users WHERE id = ? AND NOT disabled"
+	var hashedPassword []byte
+	ctx, span := startSpan(ctx, "UserModel.CheckPassword", query)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&hashedPassword)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return m.Hasher.Compare(hashedPassword, password)
+}
+
+// Method to change a user's name.
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) (err error) {
+	const query = "UPDATE users SET name = ? WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.UpdateName", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, name, id)
+	return err
+}
+
+// Method to change a user's email address. The new address has to be
+// verified again. If another user already has the address,
+// ErrDuplicateEmail is returned.
+func (m *UserModel) UpdateEmail(ctx context.Context, id int, email string) (err error) {
+	const query = "UPDATE users SET email = ?, verified = FALSE WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.UpdateEmail", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, email, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return ErrDuplicateEmail
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Method to replace a user's password with a hash of the given one.
+func (m *UserModel) SetPassword(ctx context.Context, id int, password string) (err error) {
+	hashedPassword, err := m.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	const query = "UPDATE users SET hashed_password = ? WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.SetPassword", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, string(hashedPassword), id)
+	return err
+}
+
+// Method to mark a user's email address as verified.
+func (m *UserModel) SetVerified(ctx context.Context, id int) (err error) {
+	const query = "UPDATE users SET verified = TRUE WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.SetVerified", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Method to disable or re-enable a user's account. Disabled users can't log
+// in, and Exists reports false for them so their sessions stop working.
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) (err error) {
+	const query = "UPDATE users SET disabled = ? WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.SetDisabled", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, disabled, id)
+	return err
+}
+
+// Method to replace the bio shown on a user's profile.
+func (m *UserModel) UpdateBio(ctx context.Context, id int, bio string) (err error) {
+	const query = "UPDATE users SET bio = NULLIF(?,'') WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.UpdateBio", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, query, bio, id)
+	return err
+}
+
+// Method to replace a user's avatar image. A nil image removes the avatar.
+func (m *UserModel) SetAvatar(ctx context.Context, id int, image []byte, contentType string) (err error) {
+	const query = "UPDATE users SET avatar = ?, avatar_type = NULLIF(?,'') WHERE id = ?"
+	ctx, span := startSpan(ctx, "UserModel.SetAvatar", query)
+	defer func() { endSpan(span, err) }()
+
+	if image == nil {
+		contentType = ""
+	}
+	_, err = m.DB.ExecContext(ctx, query, image, contentType, id)
+	return err
+}
+
+// Method to return a user's avatar image and its content type. If the user
+// doesn't exist or has no avatar, ErrNoRecord is returned.
+func (m *UserModel) Avatar(ctx context.Context, id int) (image []byte, contentType string, err error) {
+	const query = "SELECT avatar, avatar_type FROM users WHERE id = ? AND avatar IS NOT NULL"
+	ctx, span := startSpan(ctx, "UserModel.Avatar", query)
+	defer func() { endSpan(span, err) }()
+
+	err = m.DB.QueryRowContext(ctx, query, id).Scan(&image, &contentType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrNoRecord
+		}
+		return nil, "", err
+	}
+	return image, contentType, nil
+}
+
+// Method to create a user who logs in through an external identity
+// provider, which has already verified their email address. They get a
+// random password, which they can replace by resetting it. It returns the
+// new user's ID.
+func (m *UserModel) InsertExternal(ctx context.Context, name, email string) (id int, err error) {
+	password := make([]byte, 32)
+	_, err = rand.Read(password)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := m.Hasher.Hash(base64.RawStdEncoding.EncodeToString(password))
+	if err != nil {
+		return 0, err
+	}
+
+	const query = `INSERT INTO users (name, email, hashed_password, created, verified)
+	VALUES(?,?,?,UTC_TIMESTAMP(),TRUE)`
+	ctx, span := startSpan(ctx, "UserModel.InsertExternal", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := m.DB.ExecContext(ctx, query, name, email, string(hashedPassword))
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(lastID), nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDummyHash(t *testing.T) {
+	// The dummy hash only hides unknown email addresses if comparing with
+	// it costs as much as comparing with a real password, so it has to be
+	// made with the configured algorithm and cost.
+	for _, algorithm := range []string{Bcrypt, Argon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := DefaultPasswordHasher()
+			h.Algorithm = algorithm
+			dummy := h.dummyHash()
+			if h.NeedsRehash(dummy) {
+				t.Errorf("dummy hash %q doesn't match the hasher", dummy)
+			}
+			err := h.Compare(dummy, "pa$$word")
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got error %v; want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package sealer encrypts small secrets, such as two-factor authentication
+// keys, before they are stored in the database.
+package sealer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrOpen is returned when a sealed value can't be decrypted, because it was
+// changed or sealed with another key.
+var ErrOpen = errors.New("sealer: message authentication failed")
+
+// Define a Sealer type which encrypts with AES-256-GCM.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New returns a Sealer for a key given as 64 hexadecimal characters.
+func New(hexKey string) (*Sealer, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("sealer: the key must be 32 bytes written as 64 hexadecimal characters")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts plaintext with a random nonce, which is prepended to the
+// result.
+func (s *Sealer) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrOpen
+	}
+	plaintext, err := s.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,51 @@
+package sealer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestSealer(t *testing.T) {
+	s, err := New(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := s.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "secret") {
+		t.Errorf("sealed value contains the plaintext")
+	}
+
+	opened, err := s.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(opened), "secret")
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = s.Open(sealed)
+	assert.Equal(t, err, ErrOpen)
+
+	other, err := New(strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err = s.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Open(sealed)
+	assert.Equal(t, err, ErrOpen)
+
+	for _, key := range []string{"", "not hex", strings.Repeat("ab", 16)} {
+		_, err = New(key)
+		if err == nil {
+			t.Errorf("got no error for key %q", key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package signer
+
+import (
+	"testing"
+	"time"
+
+	"github.com.scottyfionnghall.snippetbox/internal/assert"
+)
+
+func TestSigner(t *testing.T) {
+	s := New([]byte("secret"))
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+	token := s.Sign("verify", "alice@example.com", now.Add(time.Hour))
+
+	tampered := []byte(token)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name        string
+		signer      *Signer
+		purpose     string
+		token       string
+		now         time.Time
+		wantPayload string
+		wantErr     error
+	}{
+		{
+			name:        "Valid",
+			signer:      s,
+			purpose:     "verify",
+			token:       token,
+			now:         now,
+			wantPayload: "alice@example.com",
+		},
+		{
+			name:    "Expired",
+			signer:  s,
+			purpose: "verify",
+			token:   token,
+			now:     now.Add(time.Hour),
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "Other purpose",
+			signer:  s,
+			purpose: "reset",
+			token:   token,
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Other key",
+			signer:  New([]byte("other")),
+			purpose: "verify",
+			token:   token,
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Tampered",
+			signer:  s,
+			purpose: "verify",
+			token:   string(tampered),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Malformed",
+			signer:  s,
+			purpose: "verify",
+			token:   "not-a-token",
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := tt.signer.Verify(tt.purpose, tt.token, tt.now)
+
+			assert.Equal(t, err, tt.wantErr)
+			assert.Equal(t, payload, tt.wantPayload)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"cmp"
 	"regexp"
 	"strings"
 	"unicode/utf8"
@@ -74,6 +75,12 @@ func PermitedValue[T comparable](value T, permittedValues ...T) bool {
 	return false
 }
 
+// Between() returns true if a value is within the range min to max,
+// inclusive.
+func Between[T cmp.Ordered](value, min, max T) bool {
+	return value >= min && value <= max
+}
+
 // Returns true if a value contains at least n characters
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
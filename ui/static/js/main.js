@@ -5,4 +5,225 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Add and remove file rows on the create snippet form. Rows are renumbered
+// after every change so that the submitted names stay files[0], files[1]...
+var files = document.getElementById("files");
+var fileTemplate = document.getElementById("file-template");
+var addFile = document.getElementById("add-file");
+
+function renumberFiles() {
+	var rows = files.querySelectorAll(".file");
+	for (var i = 0; i < rows.length; i++) {
+		var fields = rows[i].querySelectorAll("[name]");
+		for (var j = 0; j < fields.length; j++) {
+			var name = fields[j].getAttribute("name");
+			fields[j].setAttribute("name", name.replace(/^files\[[^\]]*\]/, "files[" + i + "]"));
+		}
+	}
+}
+
+if (files && fileTemplate && addFile) {
+	addFile.addEventListener("click", function () {
+		files.appendChild(fileTemplate.content.cloneNode(true));
+		renumberFiles();
+	});
+
+	files.addEventListener("click", function (event) {
+		if (!event.target.classList.contains("remove-file")) {
+			return;
+		}
+		var rows = files.querySelectorAll(".file");
+		if (rows.length > 1) {
+			event.target.closest(".file").remove();
+			renumberFiles();
+		}
+	});
+}
+
+// Highlight the lines selected by the URL fragment on the snippet page. The
+// fragment names a file and a line or range, e.g. #main.go-L10-L20, and
+// without a file name (#L10-L20) it refers to the first file.
+var lineHashRX = /^#(?:(.+?)-)?L(\d+)(?:-L(\d+))?$/;
+
+function highlightLines() {
+	var highlighted = document.querySelectorAll(".line.highlighted");
+	for (var i = 0; i < highlighted.length; i++) {
+		highlighted[i].classList.remove("highlighted");
+	}
+
+	var match = lineHashRX.exec(decodeURIComponent(window.location.hash));
+	if (!match) {
+		return null;
+	}
+	var file = match[1];
+	if (!file) {
+		var first = document.querySelector(".file[data-file]");
+		if (!first) {
+			return null;
+		}
+		file = first.getAttribute("data-file");
+	}
+	var start = parseInt(match[2], 10);
+	var end = match[3] ? parseInt(match[3], 10) : start;
+	if (end < start) {
+		var swap = start;
+		start = end;
+		end = swap;
+	}
+
+	var firstLine = null;
+	for (var n = start; n <= end; n++) {
+		var line = document.getElementById(file + "-L" + n);
+		if (!line) {
+			break;
+		}
+		line.classList.add("highlighted");
+		firstLine = firstLine || line;
+	}
+	// The source of markdown files is hidden until it is opened.
+	var details = firstLine && firstLine.closest("details");
+	if (details) {
+		details.open = true;
+	}
+	if (firstLine) {
+		firstLine.scrollIntoView({block: "center"});
+	}
+	return {file: file, start: start};
+}
+
+if (document.querySelector(".line")) {
+	var selection = highlightLines();
+	window.addEventListener("hashchange", function () {
+		selection = highlightLines();
+	});
+
+	// Shift-click on a line number extends the selection from the last
+	// selected line in the same file into a range.
+	document.addEventListener("click", function (event) {
+		if (!event.target.classList.contains("line-number") || !event.shiftKey || !selection) {
+			return;
+		}
+		var id = event.target.parentNode.id;
+		var dash = id.lastIndexOf("-L");
+		var file = id.slice(0, dash);
+		var number = parseInt(id.slice(dash + 2), 10);
+		if (file !== selection.file) {
+			return;
+		}
+		event.preventDefault();
+		var start = Math.min(selection.start, number);
+		var end = Math.max(selection.start, number);
+		window.location.hash = file + "-L" + start + "-L" + end;
+	});
+}
+
+// Passkeys. The server sends WebAuthn options with binary fields encoded as
+// base64url, and expects the browser's response encoded the same way. The
+// buttons stay hidden in browsers without WebAuthn.
+function base64urlToBuffer(s) {
+	var b = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+	var bytes = new Uint8Array(b.length);
+	for (var i = 0; i < b.length; i++) {
+		bytes[i] = b.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+	var bytes = new Uint8Array(buffer);
+	var s = "";
+	for (var i = 0; i < bytes.length; i++) {
+		s += String.fromCharCode(bytes[i]);
+	}
+	return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeCredentialList(list) {
+	for (var i = 0; list && i < list.length; i++) {
+		list[i].id = base64urlToBuffer(list[i].id);
+	}
+}
+
+function encodeCredential(credential) {
+	var response = {clientDataJSON: bufferToBase64url(credential.response.clientDataJSON)};
+	if (credential.response.attestationObject) {
+		response.attestationObject = bufferToBase64url(credential.response.attestationObject);
+	} else {
+		response.authenticatorData = bufferToBase64url(credential.response.authenticatorData);
+		response.signature = bufferToBase64url(credential.response.signature);
+		if (credential.response.userHandle) {
+			response.userHandle = bufferToBase64url(credential.response.userHandle);
+		}
+	}
+	return {
+		id: credential.id,
+		rawId: bufferToBase64url(credential.rawId),
+		type: credential.type,
+		response: response
+	};
+}
+
+// The postPasskey function sends a JSON body with the page's CSRF token and
+// rejects with the problem detail, or its first field error, if the server
+// refuses it.
+function postPasskey(url, csrfToken, body) {
+	return fetch(url, {
+		method: "POST",
+		headers: {"Content-Type": "application/json", "X-CSRF-Token": csrfToken},
+		body: body ? JSON.stringify(body) : null
+	}).then(function (response) {
+		return response.json().then(function (data) {
+			if (!response.ok) {
+				var errors = data.errors ? Object.values(data.errors) : [];
+				throw new Error(errors[0] || data.detail || "Something went wrong.");
+			}
+			return data;
+		});
+	});
+}
+
+function passkeyCeremony(container, run) {
+	if (!container || !window.PublicKeyCredential) {
+		return;
+	}
+	container.hidden = false;
+	var csrfToken = container.getAttribute("data-csrf-token");
+	var error = container.querySelector(".error");
+	container.querySelector("button").addEventListener("click", function () {
+		error.textContent = "";
+		run(csrfToken).then(function (data) {
+			window.location = data.redirect;
+		}).catch(function (err) {
+			error.textContent = err.message;
+		});
+	});
+}
+
+passkeyCeremony(document.getElementById("passkey-add"), function (csrfToken) {
+	var name = document.getElementById("passkey-name").value;
+	var password = document.getElementById("passkey-password").value;
+	return postPasskey("/account/passkeys/begin", csrfToken,
+		{current_password: password}).then(function (options) {
+		var publicKey = options.publicKey;
+		publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+		publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+		decodeCredentialList(publicKey.excludeCredentials);
+		return navigator.credentials.create({publicKey: publicKey});
+	}).then(function (credential) {
+		return postPasskey("/account/passkeys/finish?name=" + encodeURIComponent(name), csrfToken,
+			encodeCredential(credential));
+	});
+});
+
+passkeyCeremony(document.getElementById("passkey-login"), function (csrfToken) {
+	return postPasskey("/user/login/passkey/begin", csrfToken).then(function (options) {
+		var publicKey = options.publicKey;
+		publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+		decodeCredentialList(publicKey.allowCredentials);
+		return navigator.credentials.get({publicKey: publicKey});
+	}).then(function (credential) {
+		return postPasskey("/user/login/passkey/finish", csrfToken, encodeCredential(credential));
+	});
+});